@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Return the technology of the battery, like Li-ion, "" when unknown
+func technology() string { // I:batpath
+	tech := mustRead("technology")
+	if tech == "Unknown" {
+		return ""
+	}
+	return tech
+}
+
+// Return when the battery was made, from the manufacture_year, _month and
+// _day values that some drivers expose, the zero time when it is not known
+func manufactured() time.Time { // I:batpath
+	_, err := os.Stat(filepath.Join(batpath, "manufacture_year"))
+	if err != nil {
+		return time.Time{}
+	}
+	year, err := strconv.Atoi(mustRead("manufacture_year"))
+	if err != nil || year < 1990 {
+		return time.Time{}
+	}
+	month, day := 1, 1
+	_, err = os.Stat(filepath.Join(batpath, "manufacture_month"))
+	if err == nil {
+		month, _ = strconv.Atoi(mustRead("manufacture_month"))
+	}
+	_, err = os.Stat(filepath.Join(batpath, "manufacture_day"))
+	if err == nil {
+		day, _ = strconv.Atoi(mustRead("manufacture_day"))
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		month, day = 1, 1
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// Return the whole months from made until now, 0 when made is unknown
+func ageMonths(made, now time.Time) int {
+	if made.IsZero() || now.Before(made) {
+		return 0
+	}
+	months := (now.Year()-made.Year())*12 + int(now.Month()) - int(made.Month())
+	if now.Day() < made.Day() {
+		months--
+	}
+	return months
+}
+
+// Format an age in months for people, like "2 years 3 months"
+func formatAge(months int) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case months < 12:
+		return plural(months, "month")
+	case months%12 == 0:
+		return plural(months/12, "year")
+	}
+	return plural(months/12, "year") + " " + plural(months%12, "month")
+}
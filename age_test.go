@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgeMonths(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		made   time.Time
+		months int
+	}{
+		{time.Time{}, 0},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC), 1},
+		{time.Date(2024, 2, 16, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 38},
+		{time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, test := range tests {
+		months := ageMonths(test.made, now)
+		if months != test.months {
+			t.Errorf("ageMonths(%v) = %d, want %d", test.made, months, test.months)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		months int
+		age    string
+	}{
+		{0, "0 months"},
+		{1, "1 month"},
+		{12, "1 year"},
+		{13, "1 year 1 month"},
+		{38, "3 years 2 months"},
+	}
+	for _, test := range tests {
+		age := formatAge(test.months)
+		if age != test.age {
+			t.Errorf("formatAge(%d) = %q, want %q", test.months, age, test.age)
+		}
+	}
+}
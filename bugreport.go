@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const bugreportfile = "bat-bugreport.tar.gz"
+
+// Files in the bug report, by their name in the tarball
+type bundle map[string]string
+
+// Add the regular files in dir, which are the values for a sysfs device
+func (b bundle) addDir(name, dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			b[name+"/"+entry.Name()] = readFile(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// Add the files matching pattern under their own path
+func (b bundle) addGlob(pattern string) {
+	files, _ := filepath.Glob(pattern)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err == nil {
+			b[strings.TrimPrefix(file, "/")] = string(content)
+		}
+	}
+}
+
+// Add the output of a command, or why it failed
+func (b bundle) addCommand(name string, args ...string) {
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		output = append(output, []byte(strings.Join(args, " ")+": "+err.Error()+"\n")...)
+	}
+	b[name] = string(output)
+}
+
+// Collect what is needed to look into an issue: the doctor findings, the
+// status, the sysfs values, the persistence files and their journal lines
+func collectBugreport() bundle {
+	b := bundle{"version": "bat " + version + "\n" + readFile("/proc/version") + "\n"}
+	b.addDir("dmi", "/sys/class/dmi/id")
+	b["doctor"] = formatDoctor(doctor())
+	batteries := findBatteries()
+	for i, battery := range batteries {
+		selectBattery(battery, i)
+		data, _ := json.MarshalIndent(status(), "", "\t")
+		b["status/"+bat+".json"] = string(data) + "\n"
+		b.addDir("sysfs/"+bat, battery)
+	}
+	for _, file := range []string{huaweifile, limitfiles["lg"], limitfiles["sony"]} {
+		_, err := os.Stat(file)
+		if err == nil {
+			b[strings.TrimPrefix(file, "/")] = readFile(file) + "\n"
+		}
+	}
+	for _, pattern := range []string{services + prefix + "*", sleepdir + prefix + "*", tlpdir + "*" + prefix + "*",
+		tmpfilesdir + prefix + "*", grantstate, statedir + "persist-test-*", statedir + "calibration-*", statedir + "power-*",
+		udevdir + "*" + prefix + "*", initdir + prefix + "*",
+		svdir + prefix + "*/run", zzzdir + prefix + "*", s6dir + prefix + "*/up", dinitdir + prefix + "*",
+		crondir + prefix + "*", pmhookdir + "*" + prefix + "*", configfile} {
+		b.addGlob(pattern)
+	}
+	b.addCommand("journal", "journalctl", "--no-pager", "-n", "200", "-u", prefix+"*")
+	b.addCommand("systemd", "systemctl", "--version")
+	for name, content := range b {
+		b[name] = redact(content)
+	}
+	return b
+}
+
+// Write the bundle to path as a gzipped tarball
+func writeBugreport(path string, b bundle) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	var names []string
+	for name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	for _, name := range names {
+		content := b[name]
+		header := &tar.Header{Name: "bat-bugreport/" + name, Mode: 0o644, Size: int64(len(content)), ModTime: now}
+		err = tw.WriteHeader(header)
+		if err == nil {
+			_, err = tw.Write([]byte(content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
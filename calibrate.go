@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	calibrateLow  = 10 // Level to discharge to
+	calibratePoll = time.Minute
+)
+
+// Return the full charge capacity, in µAh or µWh, 0 when unknown
+func fullCapacity() int { // I:batpath
+	full, err := strconv.Atoi(mustRead("charge_full"))
+	if err != nil {
+		full, _ = strconv.Atoi(mustRead("energy_full"))
+	}
+	return full
+}
+
+// Wait until done returns true for the level and status, showing the
+// progress as step
+func waitFor(step string, done func(level int, status string) bool) { // I:bat,config
+	for {
+		level, _ := strconv.Atoi(mustRead("capacity"))
+		status := mustRead("status")
+		if done(level, status) {
+			return
+		}
+		if !jsonOutput {
+			fmt.Printf("[%s] %s: %d%% %s\n", label(), step, level, status)
+		}
+		time.Sleep(calibratePoll)
+	}
+}
+
+// Charge to full, discharge to calibrateLow and charge to full again, so the
+// gauge learns the capacity, then put the thresholds back; return the full
+// charge capacity before and after
+func calibrate() (int, int, error) { // I:bat,batpath
+	before := fullCapacity()
+	release := inhibitSleep("bat: calibrating battery " + bat)
+	defer release()
+	start, limit := getThresholds()
+	discharge := hasBehaviour("force-discharge")
+	restore := func() {
+		if discharge {
+			setBehaviour("auto")
+		}
+		setThresholds(start, limit)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		restore()
+		errexit("calibration interrupted, the charge limit is back to " + strconv.Itoa(limit))
+	}()
+	defer signal.Stop(interrupt)
+
+	full := func(level int, status string) bool { return status == "Full" || level >= 100 }
+	err := setThresholds(-1, 100)
+	if err != nil {
+		return before, 0, err
+	}
+	waitFor("Charging to full", full)
+	if discharge {
+		err = setBehaviour("force-discharge")
+		if err != nil {
+			restore()
+			return before, 0, err
+		}
+		waitFor("Discharging", func(level int, _ string) bool { return level <= calibrateLow })
+		err = setBehaviour("auto")
+		if err != nil {
+			restore()
+			return before, 0, err
+		}
+	} else {
+		if !jsonOutput {
+			fmt.Printf("[%s] Unplug the charger, it can be plugged in again at %d%%\n", label(), calibrateLow)
+		}
+		waitFor("Discharging", func(level int, _ string) bool { return level <= calibrateLow })
+		if !jsonOutput {
+			fmt.Printf("[%s] Plug in the charger\n", label())
+		}
+	}
+	waitFor("Charging to full", full)
+	restore()
+	after := fullCapacity()
+	return before, after, recordCalibration(before, after)
+}
+
+// Add the full charge capacity before and after to the calibration log of
+// the battery
+func recordCalibration(before, after int) error { // I:calibrationlog
+	err := os.MkdirAll(statedir, 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(calibrationlog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s %d %d\n", time.Now().Format(time.RFC3339), before, after)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Return the interval of a calibration schedule like 90d, in days or as a
+// Go duration like 2160h, at least a day
+func parseInterval(arg string) (time.Duration, error) {
+	invalid := errors.New("interval must be a number of days like 90d, or 'off'")
+	var interval time.Duration
+	if days, found := strings.CutSuffix(arg, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, invalid
+		}
+		interval = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			return 0, invalid
+		}
+		interval = d
+	}
+	if interval < 24*time.Hour {
+		return 0, invalid
+	}
+	return interval, nil
+}
+
+// Return when the battery got calibrated last, or when the schedule got
+// installed when it never was, the zero time when neither is known
+func lastCalibration() time.Time { // I:calibrationlog
+	var last time.Time
+	content, _ := os.ReadFile(calibrationlog)
+	for _, line := range strings.Split(string(content), "\n") {
+		date, _, _ := strings.Cut(line, " ")
+		t, err := time.Parse(time.RFC3339, date)
+		if err == nil {
+			last = t
+		}
+	}
+	if !last.IsZero() {
+		return last
+	}
+	info, err := os.Stat(services + unitName("calibrate"))
+	if err == nil {
+		return info.ModTime()
+	}
+	return last
+}
+
+func renderCalibrateService(self string, interval string) string { // I:bat
+	return fmt.Sprintf(calibrateservice, bat, interval, self, bat, interval)
+}
+
+func renderCalibrateTimer() string { // I:bat
+	return fmt.Sprintf(calibratetimer, bat)
+}
+
+// Install and start the timer that calibrates the battery when the last
+// calibration is interval ago
+func scheduleCalibration(interval string) error { // I:bat
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	service := unitName("calibrate")
+	timer := strings.TrimSuffix(service, ".service") + ".timer"
+	err = writeUnitFile(services+service, renderCalibrateService(self, interval), 0)
+	if err != nil {
+		return err
+	}
+	err = writeUnitFile(services+timer, renderCalibrateTimer(), 0)
+	if err != nil {
+		return err
+	}
+	err = enableUnits(timer)
+	if err != nil {
+		return err
+	}
+	return startUnit(timer)
+}
+
+// Stop and remove the calibration timer, return whether there was one
+func unscheduleCalibration() bool { // I:bat
+	service := unitName("calibrate")
+	timer := strings.TrimSuffix(service, ".service") + ".timer"
+	disableUnits(timer)
+	stopUnit(timer)
+	stopUnit(service)
+	err := removeFile(services + timer)
+	removeFile(services + service)
+	daemonReload()
+	return err == nil
+}
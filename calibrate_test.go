@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		arg      string
+		interval time.Duration
+		fails    bool
+	}{
+		{"90d", 90 * day, false},
+		{"1d", day, false},
+		{"2160h", 90 * day, false},
+		{"12h", 0, true},
+		{"0d", 0, true},
+		{"d", 0, true},
+		{"3m", 0, true},
+		{"quarterly", 0, true},
+	}
+	for _, test := range tests {
+		interval, err := parseInterval(test.arg)
+		if (err != nil) != test.fails {
+			t.Errorf("parseInterval(%q) error: %v, want failure: %v", test.arg, err, test.fails)
+			continue
+		}
+		if !test.fails && interval != test.interval {
+			t.Errorf("parseInterval(%q) = %v, want %v", test.arg, interval, test.interval)
+		}
+	}
+}
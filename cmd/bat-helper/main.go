@@ -0,0 +1,82 @@
+// bat-helper - Write a battery charge setting for bat without root
+//
+// Installed by 'bat helper --install <group>' with only the
+// cap_dac_override file capability and executable by that group, so bat
+// itself never runs as root for the single write it needs.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The files the helper writes, nothing else
+var allowlist = []string{
+	"/sys/class/power_supply/*/charge_control_end_threshold",
+	"/sys/class/power_supply/*/charge_control_start_threshold",
+	"/sys/class/power_supply/*/charge_behaviour",
+	"/sys/class/power_supply/*/charge_types",
+	"/sys/class/power_supply/*/charge_type",
+	"/sys/devices/platform/huawei-wmi/charge_control_thresholds",
+	"/sys/devices/platform/lg-laptop/battery_care_limit",
+	"/sys/devices/platform/sony-laptop/battery_care_limiter",
+}
+
+// Return whether path is in the allowlist, it has to be clean so it cannot
+// climb out of a matched directory
+func allowed(path string) bool {
+	if filepath.Clean(path) != path {
+		return false
+	}
+	for _, pattern := range allowlist {
+		match, _ := filepath.Match(pattern, path)
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Return whether value is like what the files take: a number, a pair of
+// numbers or a mode like force-discharge
+func valid(value string) bool {
+	if value == "" || len(value) > 32 {
+		return false
+	}
+	for _, c := range value {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == ' ' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage: bat-helper <file> <value>")
+		os.Exit(2)
+	}
+	path, value := os.Args[1], os.Args[2]
+	if !allowed(path) {
+		fmt.Fprintf(os.Stderr, "bat-helper: '%s' is not a charge setting\n", path)
+		os.Exit(2)
+	}
+	if !valid(value) {
+		fmt.Fprintf(os.Stderr, "bat-helper: '%s' is not a charge setting value\n", value)
+		os.Exit(2)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = f.WriteString(value)
+		if err == nil {
+			err = f.Close()
+		} else {
+			f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bat-helper:", err)
+		os.Exit(1)
+	}
+}
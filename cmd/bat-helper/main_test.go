@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/sys/class/power_supply/BAT0/charge_control_end_threshold", true},
+		{"/sys/class/power_supply/BAT1/charge_behaviour", true},
+		{"/sys/devices/platform/huawei-wmi/charge_control_thresholds", true},
+		{"/sys/class/power_supply/BAT0/../../../../etc/shadow", false},
+		{"/sys/class/power_supply/BAT0/sub/charge_control_end_threshold", false},
+		{"/sys/class/power_supply/BAT0/capacity", false},
+		{"/etc/passwd", false},
+		{"sys/class/power_supply/BAT0/charge_control_end_threshold", false},
+	}
+	for _, test := range tests {
+		if got := allowed(test.path); got != test.want {
+			t.Errorf("allowed(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"80", true},
+		{"40 70", true},
+		{"force-discharge", true},
+		{"Long Life", true},
+		{"", false},
+		{"80\n90", false},
+		{"$(reboot)", false},
+		{"12345678901234567890123456789012345", false},
+	}
+	for _, test := range tests {
+		if got := valid(test.value); got != test.want {
+			t.Errorf("valid(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
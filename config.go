@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const configfile = "/etc/bat/config.toml"
+
+// Settings from the config file by section, the keys before the first
+// section are in section ""
+var config = map[string]map[string]string{}
+
+// Parse the config file, a small part of TOML: [section] lines, key = value
+// lines with the value optionally in double quotes, and # comments
+func parseConfig(content string) (map[string]map[string]string, error) {
+	settings := map[string]map[string]string{"": {}}
+	section := ""
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("config line %d: section must end with ']'", i+1)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if settings[section] == nil {
+				settings[section] = map[string]string{}
+			}
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found || key == "" {
+			return nil, fmt.Errorf("config line %d: must be like: key = value", i+1)
+		}
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			rest := strings.TrimSpace(value[len(quoted):])
+			if err != nil || rest != "" && rest[0] != '#' {
+				return nil, fmt.Errorf("config line %d: string must be in double quotes", i+1)
+			}
+			value, _ = strconv.Unquote(quoted)
+		} else if comment := strings.Index(value, "#"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		settings[section][strings.Trim(key, `"`)] = value
+	}
+	return settings, nil
+}
+
+// Alert thresholds of the charge level from the [alerts] section, 0 when
+// not set
+var lowAlert, criticalAlert int
+
+// Defaults from the keys before the first section, which the options
+// override: battery, limit, backend (see persistBackends) and output (text
+// or json), and gap, the smallest gap between the start and end threshold
+var defaults = map[string]string{}
+
+// Read the config file, when there is one
+func loadConfig() error {
+	content, err := os.ReadFile(configfile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("cannot read config file '" + configfile + "'")
+	}
+	config, err = parseConfig(string(content))
+	if err != nil {
+		return err
+	}
+	lowAlert, criticalAlert, err = parseAlerts(config["alerts"])
+	if err != nil {
+		return err
+	}
+	_, err = parseSchedule(config["schedule"])
+	if err != nil {
+		return err
+	}
+	_, err = parsePowerRules(config["power"])
+	if err != nil {
+		return err
+	}
+	err = checkDefaults(config[""])
+	if err != nil {
+		return err
+	}
+	defaults = config[""]
+	return nil
+}
+
+// Check the values of the defaults that have a choice
+func checkDefaults(settings map[string]string) error {
+	if settings["limit"] != "" {
+		_, _, err := parseLimit(settings["limit"])
+		if err != nil {
+			return errors.New("config: limit: " + err.Error())
+		}
+	}
+	if settings["gap"] != "" {
+		gap, err := strconv.Atoi(settings["gap"])
+		if err != nil || gap < 1 || gap > 99 {
+			return errors.New("config: gap must be an integer between 1 and 99")
+		}
+	}
+	choices := map[string][]string{"backend": persistBackends(), "output": {"text", "json"}}
+	for key, values := range choices {
+		valid := settings[key] == ""
+		for _, value := range values {
+			valid = valid || settings[key] == value
+		}
+		if !valid {
+			last := len(values) - 1
+			return errors.New("config: " + key + " must be " + strings.Join(values[:last], ", ") + " or " + values[last])
+		}
+	}
+	return nil
+}
+
+// Return the low and critical thresholds in percent, the critical one has
+// to be below the low one when both are set
+func parseAlerts(alerts map[string]string) (int, int, error) {
+	var thresholds [2]int
+	for i, key := range []string{"low", "critical"} {
+		if alerts[key] == "" {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSuffix(alerts[key], "%"))
+		if err != nil || value < 1 || value > 100 {
+			return 0, 0, errors.New("config: alerts " + key + " must be an integer between 1 and 100")
+		}
+		thresholds[i] = value
+	}
+	low, critical := thresholds[0], thresholds[1]
+	if low > 0 && critical >= low {
+		return 0, 0, errors.New("config: alerts critical must be below low")
+	}
+	return low, critical, nil
+}
+
+// Return the alert that is active at level: "critical", "low" or "" when
+// there is none or the battery is not discharging
+func activeAlert(level int, status string, low, critical int) string {
+	if status != "Discharging" {
+		return ""
+	}
+	if level <= critical {
+		return "critical"
+	}
+	if level <= low {
+		return "low"
+	}
+	return ""
+}
+
+// Return the names of the profiles in the config file, from the sections
+// like [profile.travel]
+func profileNames() []string { // I:config
+	var names []string
+	for section := range config {
+		if name, found := strings.CutPrefix(section, "profile."); found && name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name of the current battery for people, from the [names] section
+func label() string { // I:bat,config
+	name := config["names"][bat]
+	if name == "" {
+		return bat
+	}
+	return name
+}
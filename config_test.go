@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	content := `# Battery names
+top = 1
+
+[names]
+BAT0 = "internal" # The one inside
+"BAT1" = slice # Under the laptop
+BAT2 = "with # hash"
+`
+	settings, err := parseConfig(content)
+	if err != nil {
+		t.Fatalf("parseConfig: %v", err)
+	}
+	want := map[string]map[string]string{
+		"":      {"top": "1"},
+		"names": {"BAT0": "internal", "BAT1": "slice", "BAT2": "with # hash"},
+	}
+	for section, keys := range want {
+		for key, value := range keys {
+			if settings[section][key] != value {
+				t.Errorf("[%s] %s = %q, want %q", section, key, settings[section][key], value)
+			}
+		}
+	}
+
+	for _, bad := range []string{"[names", "BAT0", "= internal", `BAT0 = "internal`, `BAT0 = "internal" x`} {
+		_, err := parseConfig(bad)
+		if err == nil {
+			t.Errorf("parseConfig(%q) did not fail", bad)
+		}
+	}
+}
+
+func TestParseAlerts(t *testing.T) {
+	tests := []struct {
+		alerts        map[string]string
+		low, critical int
+		fails         bool
+	}{
+		{nil, 0, 0, false},
+		{map[string]string{"low": "20", "critical": "10"}, 20, 10, false},
+		{map[string]string{"low": "20%"}, 20, 0, false},
+		{map[string]string{"critical": "5"}, 0, 5, false},
+		{map[string]string{"low": "10", "critical": "10"}, 0, 0, true},
+		{map[string]string{"low": "0"}, 0, 0, true},
+		{map[string]string{"critical": "low"}, 0, 0, true},
+	}
+	for _, test := range tests {
+		low, critical, err := parseAlerts(test.alerts)
+		if (err != nil) != test.fails {
+			t.Errorf("parseAlerts(%v) error: %v, want failure: %v", test.alerts, err, test.fails)
+			continue
+		}
+		if !test.fails && (low != test.low || critical != test.critical) {
+			t.Errorf("parseAlerts(%v) = %d %d, want %d %d", test.alerts, low, critical, test.low, test.critical)
+		}
+	}
+}
+
+func TestActiveAlert(t *testing.T) {
+	tests := []struct {
+		level         int
+		status        string
+		low, critical int
+		want          string
+	}{
+		{50, "Discharging", 20, 10, ""},
+		{20, "Discharging", 20, 10, "low"},
+		{10, "Discharging", 20, 10, "critical"},
+		{5, "Charging", 20, 10, ""},
+		{5, "Discharging", 0, 0, ""},
+		{15, "Discharging", 0, 15, "critical"},
+	}
+	for _, test := range tests {
+		got := activeAlert(test.level, test.status, test.low, test.critical)
+		if got != test.want {
+			t.Errorf("activeAlert(%d, %q, %d, %d) = %q, want %q", test.level, test.status, test.low, test.critical, got, test.want)
+		}
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	defer func(saved map[string]map[string]string) { config = saved }(config)
+	config = map[string]map[string]string{
+		"":               {},
+		"names":          {"BAT0": "internal"},
+		"profile.travel": {"limit": "100"},
+		"profile.home":   {"limit": "60"},
+		"profile.":       {"limit": "50"},
+	}
+	got := strings.Join(profileNames(), " ")
+	if got != "home travel" {
+		t.Errorf("profileNames() = %q, want %q", got, "home travel")
+	}
+}
+
+func TestCheckDefaults(t *testing.T) {
+	tests := []struct {
+		settings map[string]string
+		fails    bool
+	}{
+		{nil, false},
+		{map[string]string{"battery": "BAT1", "limit": "80", "backend": "tlp", "output": "json"}, false},
+		{map[string]string{"limit": "75-80", "backend": "systemd", "output": "text"}, false},
+		{map[string]string{"limit": "101"}, true},
+		{map[string]string{"backend": "openrc"}, false},
+		{map[string]string{"backend": "runit"}, false},
+		{map[string]string{"backend": "dinit"}, false},
+		{map[string]string{"backend": "upstart"}, true},
+		{map[string]string{"output": "yaml"}, true},
+		{map[string]string{"gap": "4"}, false},
+		{map[string]string{"gap": "0"}, true},
+		{map[string]string{"gap": "wide"}, true},
+	}
+	for _, test := range tests {
+		err := checkDefaults(test.settings)
+		if (err != nil) != test.fails {
+			t.Errorf("checkDefaults(%v) error: %v, want failure: %v", test.settings, err, test.fails)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	entries, err := parseSchedule(map[string]string{"weekdays 08:00": "60", "Fri 17:00": "100", "Weekends 20:00": "75-80"})
+	if err != nil {
+		t.Fatalf("parseSchedule: %v", err)
+	}
+	want := []scheduled{{"Fri 17:00", "100"}, {"Mon..Fri 08:00", "60"}, {"Sat,Sun 20:00", "75-80"}}
+	if len(entries) != len(want) {
+		t.Fatalf("parseSchedule = %v, want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %v, want %v", i, entries[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"", "101", "high", "80-70"} {
+		_, err := parseSchedule(map[string]string{"daily": bad})
+		if err == nil {
+			t.Errorf("parseSchedule limit %q did not fail", bad)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+const (
+	crondir   = "/etc/cron.d/"
+	pmhookdir = "/etc/pm/sleep.d/"
+)
+
+// An @reboot cron entry with a pm-utils or elogind sleep hook, the last
+// resort for systems without an init system that bat knows
+type cronInit struct{ initsystem.Cron }
+
+func (cronInit) file() string { // I:bat
+	return crondir + prefix + bat
+}
+
+// The pm-utils hook when pm-utils is there, otherwise the elogind one
+func (cronInit) hook() string { // I:bat
+	_, err := os.Stat(pmhookdir)
+	if err == nil {
+		return pmhookdir + "90" + prefix + bat
+	}
+	return elogindHook()
+}
+
+func renderCron(start, limit int) string { // I:bat
+	command := strings.ReplaceAll(persistCommand(start, limit), "%", `\%`) // cron makes % a newline
+	return fmt.Sprintf(cronfile, bat, limit, command)
+}
+
+func renderPmSleep(start, limit int) string { // I:bat
+	return fmt.Sprintf(pmsleepfile, bat, limit, persistCommand(start, limit))
+}
+
+func (b cronInit) install(start, limit int) error { // I:bat
+	err := writeSystemFile(b.file(), renderCron(start, limit), 0o644)
+	if err != nil {
+		return err
+	}
+	hook := b.hook()
+	switch {
+	case strings.HasPrefix(hook, pmhookdir):
+		return writeSystemFile(hook, renderPmSleep(start, limit), 0o755)
+	case hook != "":
+		return writeSystemFile(hook, renderSleep(start, limit), 0o755)
+	}
+	return nil
+}
+
+func (b cronInit) upToDate(start, limit int) bool { // I:bat
+	if !unchanged(b.file(), renderCron(start, limit), 0o644) {
+		return false
+	}
+	hook := b.hook()
+	switch {
+	case strings.HasPrefix(hook, pmhookdir):
+		return unchanged(hook, renderPmSleep(start, limit), 0o755)
+	case hook != "":
+		return unchanged(hook, renderSleep(start, limit), 0o755)
+	}
+	return true
+}
+
+func (b cronInit) enabled() bool { // I:bat
+	_, err := os.Stat(b.file())
+	return err == nil
+}
+
+func (b cronInit) remove() bool { // I:bat
+	err := removeFile(b.file())
+	if err != nil {
+		return false
+	}
+	if hook := b.hook(); hook != "" {
+		removeFile(hook)
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+const dinitdir = "/etc/dinit.d/"
+
+// A scripted service of dinit that boot waits for, like on Chimera Linux
+type dinitInit struct{ initsystem.Dinit }
+
+func (dinitInit) file() string { // I:bat
+	return dinitdir + prefix + bat
+}
+
+func (dinitInit) hook() string { return elogindHook() }
+
+func renderDinit(start, limit int) string { // I:bat
+	return fmt.Sprintf(dinitfile, bat, limit, doubleQuote(persistCommand(start, limit)))
+}
+
+func (b dinitInit) install(start, limit int) error { // I:bat
+	err := writeSystemFile(b.file(), renderDinit(start, limit), 0o644)
+	if err != nil {
+		return err
+	}
+	if !b.enabled() {
+		err = runCommand("dinitctl", "enable", prefix+bat)
+		if err != nil {
+			return err
+		}
+	}
+	return installElogindHook(start, limit)
+}
+
+func (b dinitInit) upToDate(start, limit int) bool { // I:bat
+	return unchanged(b.file(), renderDinit(start, limit), 0o644) && b.enabled() && elogindHookUpToDate(start, limit)
+}
+
+func (dinitInit) enabled() bool { // I:bat
+	_, err := os.Lstat(dinitdir + "boot.d/" + prefix + bat)
+	return err == nil
+}
+
+func (b dinitInit) remove() bool { // I:bat
+	_, err := os.Stat(b.file())
+	if err != nil {
+		return false
+	}
+	runCommand("dinitctl", "disable", prefix+bat)
+	removeFile(b.file())
+	if hook := b.hook(); hook != "" {
+		removeFile(hook)
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kernel modules that can give a charge limit or charge modes
+var chargeModules = []string{
+	"asus_nb_wmi", "thinkpad_acpi", "huawei_wmi", "lg_laptop", "sony_laptop", "toshiba_acpi",
+	"msi_ec", "ec_sys", "framework_laptop", "cros_charge_control", "macsmc_battery", "applesmc",
+	"dell_laptop", "system76_acpi", "samsung_galaxybook", "surface_battery",
+}
+
+// What enables the charge limit for the laptops of a vendor, by the start
+// of sys_vendor
+var vendorHints = [][2]string{
+	{"ASUS", "load kernel module asus_nb_wmi"},
+	{"LENOVO", "load kernel module thinkpad_acpi on ThinkPads, other Lenovo laptops need a newer kernel"},
+	{"HUAWEI", "load kernel module huawei_wmi"},
+	{"LG Electronics", "load kernel module lg_laptop"},
+	{"Sony", "load kernel module sony_laptop"},
+	{"TOSHIBA", "load kernel module toshiba_acpi"},
+	{"Dynabook", "load kernel module toshiba_acpi"},
+	{"Micro-Star", "load kernel module msi_ec, or ec_sys with 'options ec_sys write_support=1'"},
+	{"Framework", "load kernel module framework_laptop, or install the Framework ectool"},
+	{"Google", "load kernel module cros_charge_control (kernel 6.12+), or install ectool"},
+	{"Apple", "Apple silicon needs kernel module macsmc_battery, Intel Macs a kernel that exposes the BCLM key"},
+	{"Dell", "load kernel module dell_laptop, or set the Custom charge mode in the BIOS"},
+	{"System76", "load kernel module system76_acpi"},
+	{"SAMSUNG", "load kernel module samsung_galaxybook"},
+	{"Microsoft", "enable Battery Limit mode in the Surface UEFI settings"},
+}
+
+// Outcome of a doctor check, Battery is empty for the system checks
+type finding struct {
+	Battery   string `json:"battery,omitempty"`
+	Check     string `json:"check"`
+	Supported bool   `json:"supported"`
+	Detail    string `json:"detail"`
+	Hint      string `json:"hint,omitempty"`
+}
+
+// Return what enables the charge limit on laptops of vendor
+func vendorHint(vendor string) string {
+	for _, hint := range vendorHints {
+		if strings.HasPrefix(strings.ToUpper(vendor), strings.ToUpper(hint[0])) {
+			return hint[1]
+		}
+	}
+	return "check whether a newer kernel has a driver for this laptop, or use TLP"
+}
+
+// Return the loaded modules among chargeModules
+func loadedModules() []string {
+	var loaded []string
+	for _, module := range chargeModules {
+		_, err := os.Stat("/sys/module/" + module)
+		if err == nil {
+			loaded = append(loaded, module)
+		}
+	}
+	return loaded
+}
+
+func yesNo(supported bool) string {
+	if supported {
+		return "yes"
+	}
+	return "no"
+}
+
+// Check the system and every battery for what bat needs
+func doctor() []finding {
+	vendor := readFile(dmivendor)
+	product := strings.TrimSpace(vendor + " " + readFile("/sys/class/dmi/id/product_name"))
+	if product == "" {
+		product = "unknown"
+	}
+	findings := []finding{{Check: "Vendor", Supported: vendor != "", Detail: product}}
+	kernel := finding{Check: "Kernel", Supported: checkKernel() == nil, Detail: kernelRelease()}
+	if !kernel.Supported {
+		kernel.Hint = fmt.Sprintf("upgrade to Linux %d.%d or later", minKernelMajor, minKernelMinor)
+	}
+	findings = append(findings, kernel)
+	version, err := systemdVersion()
+	systemd := finding{Check: "Systemd", Supported: err == nil && version >= minSystemd, Detail: strconv.Itoa(version)}
+	if err != nil {
+		systemd.Detail = err.Error()
+	}
+	switch {
+	case preflight("systemd-running") != nil:
+		systemd.Hint = "persist without systemd through: " + persistAlternative()
+	case !systemd.Supported:
+		systemd.Hint = fmt.Sprintf("persist needs systemd %d or later, or use 'persist --via-tlp'", minSystemd)
+	}
+	findings = append(findings, systemd)
+	modules := finding{Check: "Modules", Supported: true, Detail: strings.Join(loadedModules(), " ")}
+	if modules.Detail == "" {
+		modules.Supported, modules.Detail = false, "none of the charge limit drivers"
+	}
+	findings = append(findings, modules)
+	if s := sandbox(); s != "" {
+		findings = append(findings, finding{Check: "Sandbox", Detail: s,
+			Hint: "bat can only read the battery here, run the other commands on the host: " + hostCommand(s)})
+	}
+	if manager := desktopManager(); manager != "" {
+		findings = append(findings, finding{Check: "Managed", Supported: true, Detail: manager,
+			Hint: "bat only changes the limit with --takeover"})
+	}
+
+	batteries := findBatteries()
+	if batteries == nil {
+		findings = append(findings, finding{Check: "Battery", Detail: "no battery device in " + syspath,
+			Hint: "nothing to limit without a battery driver"})
+	}
+	for i, battery := range batteries {
+		selectBattery(battery, i)
+		limit := finding{Battery: bat, Check: "Charge limit", Supported: true, Detail: "yes, through " + thresholdpath}
+		err := checkDriver()
+		switch {
+		case err != nil:
+			limit.Supported, limit.Detail, limit.Hint = false, err.Error(), vendorHint(vendor)
+		case driver != "":
+			limit.Detail = "yes, through the " + driver + " driver"
+		}
+		if limits := supportedLimits(); limit.Supported && limits != nil && len(limits) < 5 {
+			limit.Detail += ", only " + strings.Trim(fmt.Sprint(limits), "[]")
+		}
+		findings = append(findings, limit)
+		if !limit.Supported {
+			continue
+		}
+
+		modes, _ := chargeModes()
+		pause := hasBehaviour("inhibit-charge")
+		findings = append(findings,
+			finding{Battery: bat, Check: "Start threshold", Supported: hasStart(), Detail: yesNo(hasStart())},
+			finding{Battery: bat, Check: "Charge modes", Supported: modes != nil, Detail: yesNo(modes != nil)},
+			finding{Battery: bat, Check: "Pause charging", Supported: pause, Detail: yesNo(pause)})
+		if stale, _ := staleUnit(); stale {
+			findings = append(findings, finding{Battery: bat, Check: "Persistence units",
+				Detail: "written by another version of bat: " + unitTemplate(), Hint: "run 'sudo bat repair' to regenerate it"})
+		}
+		if viaTool() {
+			findings = append(findings, finding{Battery: bat, Check: "Boot hooks", Detail: "no, the driver works through a tool",
+				Hint: "persist --test, persist --inhibit-boot, earlyboot and grant need a kernel driver"})
+		}
+	}
+	return findings
+}
+
+// Format the findings for people, a hint on the line after what is missing
+func formatDoctor(findings []finding) string { // I:config
+	var text string
+	for _, f := range findings {
+		if f.Battery != "" {
+			bat = f.Battery
+			text += "[" + label() + "] "
+		}
+		text += f.Check + ": " + f.Detail + "\n"
+		if f.Hint != "" {
+			text += "  Hint: " + f.Hint + "\n"
+		}
+	}
+	return text
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestVendorHint(t *testing.T) {
+	tests := []struct {
+		vendor, want string
+	}{
+		{"ASUSTeK COMPUTER INC.", "load kernel module asus_nb_wmi"},
+		{"LENOVO", "load kernel module thinkpad_acpi on ThinkPads, other Lenovo laptops need a newer kernel"},
+		{"Micro-Star International Co., Ltd.", "load kernel module msi_ec, or ec_sys with 'options ec_sys write_support=1'"},
+		{"Dell Inc.", "load kernel module dell_laptop, or set the Custom charge mode in the BIOS"},
+		{"Dynabook Inc.", "load kernel module toshiba_acpi"},
+		{"Samsung Electronics Co., Ltd.", "load kernel module samsung_galaxybook"},
+		{"", "check whether a newer kernel has a driver for this laptop, or use TLP"},
+	}
+	for _, test := range tests {
+		got := vendorHint(test.vendor)
+		if got != test.want {
+			t.Errorf("vendorHint(%q) = %q, want %q", test.vendor, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// With --dry-run, persist and remove change nothing and only list what
+// they would do, in planned
+var (
+	dryRun  bool
+	planned []string
+)
+
+// Record an action that --dry-run skips, like "write /etc/x", once
+func plan(action string) { // I:bat,jsonOutput
+	for _, p := range planned {
+		if p == action {
+			return
+		}
+	}
+	planned = append(planned, action)
+	if !jsonOutput {
+		fmt.Printf("[%s] Would %s\n", label(), action)
+	}
+}
+
+// Remove file, which fails like os.Remove when it is not there
+func removeFile(file string) error {
+	if !dryRun {
+		return os.Remove(file)
+	}
+	_, err := os.Lstat(file)
+	if err == nil {
+		plan("remove " + file)
+	}
+	return err
+}
+
+// Remove dir with its contents, a missing dir is no error
+func removeAll(dir string) error {
+	if !dryRun {
+		return os.RemoveAll(dir)
+	}
+	_, err := os.Lstat(dir)
+	if err == nil {
+		plan("remove " + dir + " with its contents")
+	}
+	return nil
+}
+
+// Create dir with its parents
+func makeDir(dir string, mode os.FileMode) error {
+	if !dryRun {
+		return os.MkdirAll(dir, mode)
+	}
+	_, err := os.Stat(dir)
+	if err != nil {
+		plan("create directory " + dir)
+	}
+	return nil
+}
+
+// Make link point to target
+func symlink(target, link string) error {
+	if !dryRun {
+		return os.Symlink(target, link)
+	}
+	plan("link " + link + " to " + target)
+	return nil
+}
+
+// Make root the owner of path, with mode
+func giveToRoot(path string, mode os.FileMode) error {
+	if !dryRun {
+		err := os.Chown(path, 0, 0)
+		if err != nil {
+			return err
+		}
+		return os.Chmod(path, mode)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != 0 || stat.Gid != 0 || info.Mode().Perm() != mode {
+		plan(fmt.Sprintf("give %s to root with mode %o", path, mode))
+	}
+	return nil
+}
+
+// Run a command that changes the system
+func runCommand(name string, args ...string) error {
+	if !dryRun {
+		return exec.Command(name, args...).Run()
+	}
+	plan("run " + strings.Join(append([]string{name}, args...), " "))
+	return nil
+}
+
+// Report the end of a dry run with the actions it skipped
+func reportDryRun() {
+	if planned == nil {
+		report("Dry run, nothing to change", map[string]any{"dry_run": true, "planned": []string{}})
+		return
+	}
+	report("Dry run, nothing changed", map[string]any{"dry_run": true, "planned": planned})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "chargelimit-BAT0")
+	err := os.WriteFile(file, []byte("x"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun, jsonOutput = true, true
+	defer func() { dryRun, jsonOutput, planned = false, false, nil }()
+
+	if err = removeFile(file); err != nil {
+		t.Errorf("removeFile(%q) error: %v", file, err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if err = removeFile(missing); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("removeFile(%q) error: %v, want os.ErrNotExist", missing, err)
+	}
+	writeSystemFile(file, "y", 0o644)
+	runCommand("udevadm", "control", "--reload")
+	runCommand("udevadm", "control", "--reload")
+	err = os.Chmod(file, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = giveToRoot(file, 0o644); err != nil {
+		t.Errorf("giveToRoot(%q) error: %v", file, err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0o600 {
+		t.Errorf("dry run changed the mode of %q to %o", file, info.Mode().Perm())
+	}
+	content, _ := os.ReadFile(file)
+	if string(content) != "x" {
+		t.Errorf("dry run changed %q to %q", file, content)
+	}
+	want := []string{"remove " + file, "write " + file, "run udevadm control --reload", "give " + file + " to root with mode 644"}
+	if len(planned) != len(want) {
+		t.Fatalf("planned = %q, want %q", planned, want)
+	}
+	for i, action := range planned {
+		if action != want[i] {
+			t.Errorf("planned[%d] = %q, want %q", i, action, want[i])
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Chromebooks without the cros-charge-control driver only take the
+// thresholds through the embedded controller, with the ectool utility
+const crosec = "/dev/cros_ec"
+
+// The battery sustainer of the Chromebook embedded controller
+type crosecBackend struct{}
+
+func (crosecBackend) name() string { return "crosec" }
+
+func (crosecBackend) detect() bool {
+	_, err := exec.LookPath("ectool")
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(crosec)
+	return err == nil
+}
+
+func (crosecBackend) get() (int, int) { return crosecThresholds() }
+
+func (crosecBackend) set(start, limit int) error { return crosecSet(start, limit) }
+
+func (crosecBackend) capabilities() capabilities { return capabilities{start: true} }
+
+func (crosecBackend) command(start, limit int) string { return crosecCommand(start, limit) }
+
+// Return the threshold arguments for ectool: the battery sustainer keeps
+// the charge between start and limit, without arguments it is off
+func crosecArgs(start, current, limit int) []string {
+	args := []string{"chargecontrol", "normal"}
+	if limit == 100 {
+		return args
+	}
+	if start < 0 {
+		start = current
+	}
+	if start < 0 || start > limit {
+		start = limit
+	}
+	return append(args, strconv.Itoa(start), strconv.Itoa(limit))
+}
+
+// Parse the output of 'ectool chargecontrol', with a line like:
+// Battery sustainer = on (75% ~ 80%)
+func parseSustainer(output string) (int, int, error) {
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "Battery sustainer = ") {
+			continue
+		}
+		if strings.Contains(line, "= off") {
+			return -1, 100, nil
+		}
+		var start, end int
+		_, err := fmt.Sscanf(line, "Battery sustainer = on (%d%% ~ %d%%)", &start, &end)
+		return start, end, err
+	}
+	return -1, 0, errors.New("no battery sustainer in ectool output")
+}
+
+func crosecThresholds() (int, int) {
+	output, err := exec.Command("ectool", "chargecontrol").Output()
+	if err != nil {
+		return -1, 0
+	}
+	start, end, err := parseSustainer(string(output))
+	if err != nil {
+		return -1, 0
+	}
+	return start, end
+}
+
+func crosecSet(start, limit int) error {
+	current, _ := crosecThresholds()
+	output, err := exec.Command("ectool", crosecArgs(start, current, limit)...).CombinedOutput()
+	if err != nil && strings.Contains(string(output), "ermission") {
+		return os.ErrPermission
+	}
+	return err
+}
+
+// Command for the persistence scripts, with the full path of ectool as
+// they run without the PATH of the user
+func ectoolCommand(args []string) string {
+	ectool, err := exec.LookPath("ectool")
+	if err != nil {
+		ectool = "ectool"
+	}
+	return ectool + " " + strings.Join(args, " ")
+}
+
+func crosecCommand(start, limit int) string {
+	current, _ := crosecThresholds()
+	return ectoolCommand(crosecArgs(start, current, limit))
+}
+
+// Framework laptops without the framework_laptop driver take the limit
+// through the fwchargelimit command of the Framework ectool
+func isFramework() bool {
+	return strings.HasPrefix(readFile(dmivendor), "Framework")
+}
+
+// The charge limit of the Framework embedded controller, which has a
+// cros_ec too, so it gets detected before crosecBackend
+type frameworkBackend struct{}
+
+func (frameworkBackend) name() string { return "framework" }
+
+func (frameworkBackend) detect() bool {
+	_, err := exec.LookPath("ectool")
+	return err == nil && isFramework()
+}
+
+func (frameworkBackend) get() (int, int) { return -1, frameworkLimit() }
+
+func (frameworkBackend) set(start, limit int) error { return frameworkSet(limit) }
+
+func (frameworkBackend) capabilities() capabilities { return capabilities{} }
+
+func (frameworkBackend) command(start, limit int) string {
+	return ectoolCommand([]string{"fwchargelimit", strconv.Itoa(limit)})
+}
+
+// Parse the output of 'ectool fwchargelimit', the first number is the limit
+func parseChargeLimit(output string) (int, error) {
+	numbers := strings.FieldsFunc(output, func(r rune) bool { return r < '0' || r > '9' })
+	if len(numbers) == 0 {
+		return 0, errors.New("no charge limit in ectool output")
+	}
+	return strconv.Atoi(numbers[0])
+}
+
+func frameworkLimit() int {
+	output, err := exec.Command("ectool", "fwchargelimit").Output()
+	if err != nil {
+		return 0
+	}
+	limit, _ := parseChargeLimit(string(output))
+	return limit
+}
+
+func frameworkSet(limit int) error {
+	output, err := exec.Command("ectool", "fwchargelimit", strconv.Itoa(limit)).CombinedOutput()
+	if err != nil && strings.Contains(string(output), "ermission") {
+		return os.ErrPermission
+	}
+	return err
+}
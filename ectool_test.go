@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSustainer(t *testing.T) {
+	tests := []struct {
+		output     string
+		start, end int
+		fails      bool
+	}{
+		{"Charge mode = NORMAL (0)\nBattery sustainer = on (75% ~ 80%)\n", 75, 80, false},
+		{"Charge mode = NORMAL (0)\nBattery sustainer = off (-1% ~ -1%)\n", -1, 100, false},
+		{"Charge mode = IDLE (1)\n", -1, 0, true},
+		{"Battery sustainer = on (x)\n", 0, 0, true},
+	}
+	for _, test := range tests {
+		start, end, err := parseSustainer(test.output)
+		if (err != nil) != test.fails {
+			t.Errorf("parseSustainer(%q) error: %v, want failure: %v", test.output, err, test.fails)
+			continue
+		}
+		if !test.fails && (start != test.start || end != test.end) {
+			t.Errorf("parseSustainer(%q) = %d %d, want %d %d", test.output, start, end, test.start, test.end)
+		}
+	}
+}
+
+func TestCrosecArgs(t *testing.T) {
+	tests := []struct {
+		start, current, limit int
+		want                  string
+	}{
+		{75, 60, 80, "chargecontrol normal 75 80"},
+		{-1, 60, 80, "chargecontrol normal 60 80"},
+		{-1, -1, 80, "chargecontrol normal 80 80"},
+		{-1, 85, 80, "chargecontrol normal 80 80"},
+		{-1, 60, 100, "chargecontrol normal"},
+	}
+	for _, test := range tests {
+		got := strings.Join(crosecArgs(test.start, test.current, test.limit), " ")
+		if got != test.want {
+			t.Errorf("crosecArgs(%d, %d, %d) = %q, want %q", test.start, test.current, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestParseChargeLimit(t *testing.T) {
+	tests := []struct {
+		output string
+		limit  int
+		fails  bool
+	}{
+		{"Get Charge Limit: 80 %\n", 80, false},
+		{"Current charge limit: 100%\n", 100, false},
+		{"60\n", 60, false},
+		{"Limit: none\n", 0, true},
+	}
+	for _, test := range tests {
+		limit, err := parseChargeLimit(test.output)
+		if (err != nil) != test.fails {
+			t.Errorf("parseChargeLimit(%q) error: %v, want failure: %v", test.output, err, test.fails)
+			continue
+		}
+		if !test.fails && limit != test.limit {
+			t.Errorf("parseChargeLimit(%q) = %d, want %d", test.output, limit, test.limit)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Name of the path unit that watches the limit of the current battery
+func enforcePath() string { // I:bat
+	return strings.TrimSuffix(unitName("enforce"), ".service") + ".path"
+}
+
+// The service checks the limit, and sets the start along with it
+func renderEnforceService(shell string, start, limit int) string { // I:bat
+	path, value := persistWrite(start, limit)
+	return fmt.Sprintf(enforceservice, bat, limit, shell, path, value, persistCommand(start, limit))
+}
+
+func renderEnforcePath(limit int) string { // I:bat
+	path, _ := persistWrite(-1, limit)
+	return fmt.Sprintf(enforcepath, bat, limit, path, unitName("enforce"))
+}
+
+// Install and start the path unit that sets limit and start again when the
+// threshold file gets written with another value; the service only writes
+// when the value differs, so its own write does not trigger it again
+func installEnforce(shell string, start, limit int) error { // I:bat
+	err := writeUnitFile(services+unitName("enforce"), renderEnforceService(shell, start, limit), limit)
+	if err != nil {
+		return err
+	}
+	err = writeUnitFile(services+enforcePath(), renderEnforcePath(limit), limit)
+	if err != nil {
+		return err
+	}
+	err = enableUnits(enforcePath())
+	if err != nil {
+		return err
+	}
+	return startUnit(enforcePath())
+}
+
+// Move the enforced thresholds along before bat sets others itself, so the
+// path unit does not undo it; start -1 keeps the current start
+func followEnforce(start, limit int) error { // I:bat
+	file := services + unitName("enforce")
+	_, err := os.Stat(file)
+	if err != nil { // Not enforced
+		return nil
+	}
+	if start < 0 && hasStart() {
+		start, _ = getThresholds()
+	}
+	shell := findShell()
+	if unchanged(file, unitStamp(limit)+renderEnforceService(shell, start, limit), 0o644) {
+		return nil
+	}
+	return installEnforce(shell, start, limit)
+}
+
+// Stop and remove the path unit, return whether there was one
+func removeEnforce() bool { // I:bat
+	disableUnits(enforcePath())
+	stopUnit(enforcePath())
+	stopUnit(unitName("enforce"))
+	err := removeFile(services + enforcePath())
+	removeFile(services + unitName("enforce"))
+	daemonReload()
+	return err == nil
+}
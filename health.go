@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Health of the battery as shown by health and in its report. With --json
+// this is the output, see batStatus for the schema.
+type batHealth struct {
+	SchemaVersion int    `json:"schema_version"`
+	Battery       string `json:"battery"`
+	Manufacturer  string `json:"manufacturer,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Serial        string `json:"serial,omitempty"`
+	Technology    string `json:"technology,omitempty"`
+	Health        int    `json:"health"`
+	// Capacities in µAh, or µWh for batteries that report energy
+	Full         int             `json:"full"`
+	Design       int             `json:"design"`
+	Energy       bool            `json:"energy"`
+	Cycles       int             `json:"cycles,omitempty"`
+	Manufactured string          `json:"manufactured,omitempty"`
+	AgeMonths    int             `json:"age_months,omitempty"`
+	History      []capacityPoint `json:"history,omitempty"`
+}
+
+// Full charge capacity after a calibration
+type capacityPoint struct {
+	Date time.Time `json:"date"`
+	Full int       `json:"full"`
+}
+
+// Return the full charge and the design capacity, and whether they are
+// energy instead of charge
+func capacities() (int, int, bool) { // I:batpath
+	full, err := strconv.Atoi(mustRead("charge_full"))
+	if err == nil {
+		design, _ := strconv.Atoi(mustRead("charge_full_design"))
+		return full, design, false
+	}
+	full, _ = strconv.Atoi(mustRead("energy_full"))
+	design, _ := strconv.Atoi(mustRead("energy_full_design"))
+	return full, design, true
+}
+
+// Return the charge now as a rounded percentage of the design capacity,
+// 0 when unknown; capacity is relative to the full charge capacity, which
+// hides how much a worn battery lost
+func designLevel() int { // I:batpath
+	now, err := strconv.Atoi(mustRead("charge_now"))
+	if err == nil {
+		design, _ := strconv.Atoi(mustRead("charge_full_design"))
+		return percentOf(now, design)
+	}
+	now, _ = strconv.Atoi(mustRead("energy_now"))
+	design, _ := strconv.Atoi(mustRead("energy_full_design"))
+	return percentOf(now, design)
+}
+
+// Return part as a rounded percentage of whole, 0 when whole is unknown
+func percentOf(part, whole int) int {
+	if whole <= 0 || part < 0 {
+		return 0
+	}
+	return (part*100 + whole/2) / whole
+}
+
+// Return the capacity after each calibration from the calibration log
+func parseCalibrations(content string) []capacityPoint {
+	var points []capacityPoint
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			continue
+		}
+		full, err := strconv.Atoi(fields[2])
+		if err != nil || full == 0 {
+			continue
+		}
+		points = append(points, capacityPoint{date, full})
+	}
+	return points
+}
+
+func batteryHealth() batHealth { // I:bat,calibrationlog
+	h := batHealth{SchemaVersion: schemaVersion, Battery: bat, Manufacturer: mustRead("manufacturer"),
+		Model: mustRead("model_name"), Serial: mustRead("serial_number"), Technology: technology()}
+	h.Full, h.Design, h.Energy = capacities()
+	if h.Full > 0 && h.Design > 0 {
+		h.Health = h.Full * 100 / h.Design
+	}
+	h.Cycles, _ = strconv.Atoi(mustRead("cycle_count"))
+	if made := manufactured(); !made.IsZero() {
+		h.Manufactured = made.Format("2006-01-02")
+		h.AgeMonths = ageMonths(made, time.Now())
+	}
+	content, _ := os.ReadFile(calibrationlog)
+	h.History = parseCalibrations(string(content))
+	return h
+}
+
+// Format a capacity in µAh or µWh for people
+func formatCapacity(capacity int, energy bool) string {
+	if energy {
+		return fmt.Sprintf("%.1f Wh", float64(capacity)/1e6)
+	}
+	return fmt.Sprintf("%d mAh", capacity/1000)
+}
+
+// Return the lines of the health for people, without the battery label
+func formatHealth(h batHealth) []string {
+	var lines []string
+	if h.Health > 0 {
+		lines = append(lines, fmt.Sprintf("Health: %d%%, %s of %s", h.Health,
+			formatCapacity(h.Full, h.Energy), formatCapacity(h.Design, h.Energy)))
+	} else {
+		lines = append(lines, "Health cannot be determined")
+	}
+	if h.Cycles > 0 {
+		lines = append(lines, fmt.Sprintf("Cycles: %d", h.Cycles))
+	}
+	if h.Technology != "" {
+		lines = append(lines, "Technology: "+h.Technology)
+	}
+	if h.Manufactured != "" {
+		lines = append(lines, fmt.Sprintf("Age: %s (made %s)", formatAge(h.AgeMonths), h.Manufactured))
+	}
+	if model := strings.TrimSpace(h.Manufacturer + " " + h.Model); model != "" {
+		lines = append(lines, "Model: "+model)
+	}
+	if h.Serial != "" {
+		lines = append(lines, "Serial: "+h.Serial)
+	}
+	return lines
+}
+
+// Return the capacity over time as a percentage of the design capacity in
+// an SVG chart, with the current capacity at now as the last point
+func renderChart(h batHealth, now time.Time) string {
+	points := append(h.History, capacityPoint{now, h.Full})
+	if len(points) < 2 || h.Design == 0 {
+		return "<p>No capacity history yet, each 'bat calibrate' records the capacity.</p>\n"
+	}
+	const left, width, top, height = 50, 530, 10, 160
+	first, span := points[0].Date, points[len(points)-1].Date.Sub(points[0].Date)
+	if span <= 0 {
+		span = time.Second
+	}
+	chart := "<svg viewBox=\"0 0 600 200\" xmlns=\"http://www.w3.org/2000/svg\" font-size=\"11\">\n"
+	for pct := 0; pct <= 100; pct += 20 {
+		y := top + height - pct*height/100
+		chart += fmt.Sprintf("<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#ddd\"/>"+
+			"<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%d%%</text>\n", left, y, left+width, y, left-5, y+4, pct)
+	}
+	var coords []string
+	for _, p := range points {
+		pct := p.Full * 100 / h.Design
+		if pct > 100 {
+			pct = 100
+		}
+		x := left + int(float64(width)*float64(p.Date.Sub(first))/float64(span))
+		y := top + height - pct*height/100
+		coords = append(coords, fmt.Sprintf("%d,%d", x, y))
+		chart += fmt.Sprintf("<circle cx=\"%d\" cy=\"%d\" r=\"3\" fill=\"#2a6\"><title>%s: %d%%</title></circle>\n",
+			x, y, p.Date.Format("2006-01-02"), pct)
+	}
+	chart += fmt.Sprintf("<polyline points=\"%s\" fill=\"none\" stroke=\"#2a6\" stroke-width=\"2\"/>\n", strings.Join(coords, " "))
+	chart += fmt.Sprintf("<text x=\"%d\" y=\"195\">%s</text><text x=\"%d\" y=\"195\" text-anchor=\"end\">%s</text>\n",
+		left, first.Format("2006-01-02"), left+width, now.Format("2006-01-02"))
+	return chart + "</svg>\n"
+}
+
+// Render the printable report of the health, of the battery in machine
+func renderReport(h batHealth, machine string, now time.Time) string { // I:bat
+	title := strings.TrimSpace(machine + " " + label())
+	rows := fmt.Sprintf("<tr><th>Laptop</th><td>%s</td></tr>\n", html.EscapeString(machine))
+	rows += fmt.Sprintf("<tr><th>Battery</th><td>%s</td></tr>\n", html.EscapeString(label()))
+	for _, line := range formatHealth(h) {
+		name, value, found := strings.Cut(line, ": ")
+		if !found {
+			name, value = "Health", "unknown"
+		}
+		rows += fmt.Sprintf("<tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(name), html.EscapeString(value))
+	}
+	rows += fmt.Sprintf("<tr><th>Calibrations</th><td>%d</td></tr>\n", len(h.History))
+	return fmt.Sprintf(reportfile, html.EscapeString(title), rows, renderChart(h, now), now.Format("2006-01-02"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCalibrations(t *testing.T) {
+	content := "2023-06-01T10:00:00Z 4700000 4600000\n" +
+		"garbage\n" +
+		"2023-09-01T10:00:00Z 4500000 0\n" +
+		"2024-01-05T10:00:00+01:00 4450000 4400000\n"
+	points := parseCalibrations(content)
+	want := []capacityPoint{
+		{time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC), 4600000},
+		{time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC), 4400000},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("parseCalibrations() = %v, want %v", points, want)
+	}
+	for i, point := range points {
+		if !point.Date.Equal(want[i].Date) || point.Full != want[i].Full {
+			t.Errorf("parseCalibrations()[%d] = %v, want %v", i, point, want[i])
+		}
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		part, whole int
+		percent     int
+	}{
+		{3000000, 4000000, 75},
+		{2900000, 5700000, 51},
+		{4100000, 4000000, 103},
+		{3000000, 0, 0},
+		{-1, 4000000, 0},
+	}
+	for _, test := range tests {
+		percent := percentOf(test.part, test.whole)
+		if percent != test.percent {
+			t.Errorf("percentOf(%d, %d) = %d, want %d", test.part, test.whole, percent, test.percent)
+		}
+	}
+}
+
+func TestFormatCapacity(t *testing.T) {
+	tests := []struct {
+		capacity int
+		energy   bool
+		text     string
+	}{
+		{4250000, false, "4250 mAh"},
+		{57020000, true, "57.0 Wh"},
+	}
+	for _, test := range tests {
+		text := formatCapacity(test.capacity, test.energy)
+		if text != test.text {
+			t.Errorf("formatCapacity(%d, %v) = %q, want %q", test.capacity, test.energy, text, test.text)
+		}
+	}
+}
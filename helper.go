@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Where 'helper --install' puts bat-helper, which writes the charge settings
+// for a group without root, see cmd/bat-helper
+const helperfile = "/usr/local/libexec/bat-helper"
+
+// Write value to a sysfs file, through the helper when bat does not run as
+// root and the helper is installed; on failure return the error of the
+// direct write
+func writeValue(path, value string) error {
+	err := os.WriteFile(path, []byte(value), 0o644)
+	if errors.Is(err, syscall.EROFS) { // Like /sys in a sandbox
+		err = fmt.Errorf("%w: %v", os.ErrPermission, err)
+	}
+	if err == nil || !errors.Is(err, os.ErrPermission) || os.Geteuid() == 0 {
+		return err
+	}
+	_, staterr := os.Stat(helperfile)
+	if staterr != nil || exec.Command(helperfile, path, value).Run() != nil {
+		return err
+	}
+	return nil
+}
+
+// Return the bat-helper to install: next to bat, or else in the PATH
+func helperSource() (string, error) {
+	self, err := os.Executable()
+	if err == nil {
+		source := filepath.Join(filepath.Dir(self), "bat-helper")
+		_, err = os.Stat(source)
+		if err == nil {
+			return source, nil
+		}
+	}
+	source, err := exec.LookPath("bat-helper")
+	if err != nil {
+		return "", errors.New("no bat-helper next to bat or in the PATH, build it with: go build ./cmd/bat-helper")
+	}
+	return source, nil
+}
+
+// Install the helper executable by group only, with cap_dac_override as
+// its only privilege
+func installHelper(group *user.Group) error {
+	source, err := helperSource()
+	if err != nil {
+		return err
+	}
+	setcap, err := exec.LookPath("setcap")
+	if err != nil {
+		return errors.New("setcap not found, it comes with libcap")
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(helperfile), 0o755)
+	if err != nil {
+		return err
+	}
+	os.Remove(helperfile) // It may be running
+	err = writeSystemFile(helperfile, string(content), 0o750)
+	if err != nil {
+		return err
+	}
+	gid, _ := strconv.Atoi(group.Gid)
+	err = os.Chown(helperfile, 0, gid)
+	if err != nil {
+		return err
+	}
+	output, err := exec.Command(setcap, "cap_dac_override=ep", helperfile).CombinedOutput()
+	if err != nil {
+		os.Remove(helperfile)
+		return errors.New("setcap failed: " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Return the group that may run the installed helper, "" when there is none
+func helperGroup() string {
+	info, err := os.Stat(helperfile)
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	group, err := user.LookupGroupId(strconv.Itoa(int(stat.Gid)))
+	if err != nil {
+		return strconv.Itoa(int(stat.Gid))
+	}
+	return group.Name
+}
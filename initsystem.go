@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+// A way to persist the limit on a system that does not run systemd, on top
+// of the detection of the init system
+type initBackend interface {
+	initsystem.System
+	// Install the service that sets limit and start on boot, and the sleep
+	// hook; start -1 leaves the start threshold alone
+	install(start, limit int) error
+	// Whether the service is enabled
+	enabled() bool
+	// Whether the service and the sleep hook are installed for start and
+	// limit and the service is enabled, so install has nothing to do
+	upToDate(start, limit int) bool
+	// Disable and remove the service and the sleep hook, return whether
+	// there was a service
+	remove() bool
+	// Return the service file of the current battery
+	file() string
+	// Return the sleep hook of the current battery, "" when there is none
+	hook() string
+}
+
+// The init systems other than systemd, in the order of initsystem.Systems
+var inits = []initBackend{openrcInit{}, runitInit{}, s6Init{}, dinitInit{}, cronInit{}}
+
+// Return the init system when it is not systemd and bat knows it, or nil
+func detectInit() initBackend {
+	if s := initsystem.Detect(); s != nil {
+		return findInit(s.Name())
+	}
+	return nil
+}
+
+// Return the init system called name, nil for systemd or tlp
+func findInit(name string) initBackend {
+	for _, b := range inits {
+		if b.Name() == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// Return the names that persist takes after --via-
+func persistBackends() []string {
+	names := []string{"systemd", "tlp"}
+	for _, b := range inits {
+		names = append(names, b.Name())
+	}
+	return names
+}
+
+// Return the persist command that works without systemd, through the
+// detected init system, or the choices when none is detected; in a sandbox
+// the one for the host
+func persistAlternative() string {
+	if s := sandbox(); s != "" {
+		return hostCommand(s) + " persist"
+	}
+	if b := detectInit(); b != nil {
+		return "bat persist --via-" + b.Name()
+	}
+	var options []string
+	for _, b := range inits {
+		options = append(options, "--via-"+b.Name())
+	}
+	return "bat persist --via-tlp, " + strings.Join(options, ", ")
+}
+
+// Return a command line argument in double quotes, as execline and dinit
+// take them
+func doubleQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// Where elogind runs the sleep hooks, which take the same arguments as the
+// systemd ones, by distribution
+var elogindDirs = []string{"/usr/lib/elogind/system-sleep/", "/lib64/elogind/system-sleep/", "/lib/elogind/system-sleep/"}
+
+// Return the sleep hook for elogind of the current battery, "" without
+// elogind
+func elogindHook() string { // I:bat
+	for _, dir := range elogindDirs {
+		_, err := os.Stat(dir)
+		if err == nil {
+			return dir + prefix + bat
+		}
+	}
+	return ""
+}
+
+// Whether the sleep hook for elogind sets start and limit, or there is no
+// elogind
+func elogindHookUpToDate(start, limit int) bool { // I:bat
+	hook := elogindHook()
+	return hook == "" || unchanged(hook, renderSleep(start, limit), 0o755)
+}
+
+// Write the sleep hook for elogind, when there is elogind
+func installElogindHook(start, limit int) error { // I:bat
+	if hook := elogindHook(); hook != "" {
+		return writeSystemFile(hook, renderSleep(start, limit), 0o755)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Package initsystem detects the init system that runs the machine, when it
+// is one of those other than systemd that bat can persist the limit through
+package initsystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An init system other than systemd
+type System interface {
+	// Name of the init system, as in --via-<name>
+	Name() string
+	// Whether the system runs it
+	Detect() bool
+}
+
+// The init systems other than systemd, in the order they get detected, cron
+// last as it runs next to any of them
+var Systems = []System{OpenRC{}, Runit{}, S6{}, Dinit{}, Cron{}}
+
+// Return the init system when it is not systemd and is one of Systems, or nil
+func Detect() System {
+	if exists("/run/systemd/system") {
+		return nil
+	}
+	for _, s := range Systems {
+		if s.Detect() {
+			return s
+		}
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// OpenRC, like on Gentoo and Alpine
+type OpenRC struct{}
+
+func (OpenRC) Name() string { return "openrc" }
+
+func (OpenRC) Detect() bool { return exists("/run/openrc") }
+
+// runit, like on Void Linux
+type Runit struct{}
+
+func (Runit) Name() string { return "runit" }
+
+func (Runit) Detect() bool { return exists("/run/runit") }
+
+// s6-rc, like on Artix
+type S6 struct{}
+
+func (S6) Name() string { return "s6" }
+
+func (S6) Detect() bool { return exists("/run/s6-rc") }
+
+// dinit, like on Chimera Linux
+type Dinit struct{}
+
+func (Dinit) Name() string { return "dinit" }
+
+func (Dinit) Detect() bool { return exists("/run/dinitctl") }
+
+// A cron daemon that reads /etc/cron.d, next to an init system that bat
+// does not know
+type Cron struct{}
+
+func (Cron) Name() string { return "cron" }
+
+// An /etc/cron.d left behind by a package does not do, a cron daemon has
+// to run to pick up the entry
+func (Cron) Detect() bool {
+	return exists("/etc/cron.d") && cronRunning("/proc")
+}
+
+// Names of the process of the cron daemons: cronie, dcron and busybox run
+// as crond, Debian's as cron
+var cronDaemons = []string{"cron", "crond", "fcron"}
+
+// Return whether a cron daemon runs, going by the process names in proc
+func cronRunning(proc string) bool {
+	comms, _ := filepath.Glob(filepath.Join(proc, "[0-9]*", "comm"))
+	for _, comm := range comms {
+		name, err := os.ReadFile(comm)
+		if err != nil {
+			continue
+		}
+		for _, daemon := range cronDaemons {
+			if strings.TrimSpace(string(name)) == daemon {
+				return true
+			}
+		}
+	}
+	return false
+}
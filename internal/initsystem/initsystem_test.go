@@ -0,0 +1,36 @@
+package initsystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCronRunning(t *testing.T) {
+	tests := []struct {
+		comms map[string]string
+		want  bool
+	}{
+		{map[string]string{"1": "init\n", "412": "crond\n"}, true},
+		{map[string]string{"1": "runit\n", "87": "cron\n"}, true},
+		{map[string]string{"1": "openrc-init\n", "96": "cronie-helper\n"}, false},
+		{map[string]string{"1": "init\n"}, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		proc := t.TempDir()
+		for pid, comm := range test.comms {
+			err := os.Mkdir(filepath.Join(proc, pid), 0o755)
+			if err == nil {
+				err = os.WriteFile(filepath.Join(proc, pid, "comm"), []byte(comm), 0o644)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := cronRunning(proc)
+		if got != test.want {
+			t.Errorf("cronRunning(%v) = %v, want %v", test.comms, got, test.want)
+		}
+	}
+}
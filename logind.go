@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Hold a logind inhibitor lock on sleep, with why shown by
+// 'systemd-inhibit --list', until the returned function releases it.
+// The lock waits on the pid of bat, so it also goes when bat gets killed.
+// Without systemd-inhibit there is no lock.
+func inhibitSleep(why string) func() {
+	cmd := exec.Command("systemd-inhibit", "--what=sleep", "--who=bat", "--why="+why, "--mode=block",
+		"tail", "--pid="+strconv.Itoa(os.Getpid()), "-f", "/dev/null")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if cmd.Start() != nil {
+		return func() {}
+	}
+	return func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		cmd.Wait()
+	}
+}
+
+// Start a transient service that holds a logind inhibitor lock on sleep
+// while the battery is force-discharging, it ends when that stops in any way
+func inhibitDischargeSleep() error { // I:bat,batpath
+	unit := prefix + bat + "-discharge"
+	stopUnit(unit)
+	file := filepath.Join(batpath, behaviour)
+	return exec.Command("systemd-run", "--unit="+unit, "systemd-inhibit", "--what=sleep", "--who=bat",
+		"--why=bat: force-discharging battery "+bat, "--mode=block",
+		"sh", "-c", `while grep -q '\[force-discharge\]' `+file+`; do sleep 10; done`).Run()
+}
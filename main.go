@@ -3,15 +3,19 @@ package main
 
 import (
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const (
@@ -19,12 +23,70 @@ const (
 	years         = "2023-2024"
 	prefix        = "chargelimit-"
 	services      = "/etc/systemd/system/"
-	sleepfilename = "/usr/lib/systemd/system-sleep/chargelimit"
+	sleepdir      = "/usr/lib/systemd/system-sleep/"
+	tlpdir        = "/etc/tlp.d/"
+	tmpfilesdir   = "/etc/tmpfiles.d/"
+	initramfsdir  = "/etc/initramfs-tools/scripts/init-premount/"
+	dracutdir     = "/usr/lib/dracut/modules.d/"
+	statedir      = "/var/lib/bat/"
+	grantstate    = statedir + "grants"
+	upowerstatus  = "/var/lib/upower/charging-threshold-status"
 	syspath       = "/sys/class/power_supply/"
 	threshold     = "charge_control_end_threshold"
+	startvariable = "charge_control_start_threshold"
+	outputLatest  = 4 // Bump when status output gains or changes fields
+	schemaVersion = 1 // Bump when the JSON output changes incompatibly
 )
 
 var (
+	// Variables available to the plumbing commands
+	plumbing = map[string]string{
+		"threshold": threshold,
+		"level":     "capacity",
+		"status":    "status",
+		"behaviour": behaviour,
+	}
+	// Other names of the commands
+	commands = map[string]string{
+		"s": "status", "-s": "status", "--status": "status",
+		"l": "limit", "-l": "limit", "--limit": "limit",
+		"p": "persist", "-p": "persist", "--persist": "persist",
+		"r": "remove", "-r": "remove", "--remove": "remove",
+		"h": "help", "-h": "help", "--help": "help",
+		"V": "version", "v": "version", "-V": "version", "-v": "version", "--version": "version",
+	}
+	// Commands that do not need the config file, so an error in it only
+	// gets a warning
+	configless = map[string]bool{"help": true, "version": true, "completion": true}
+	// Number of arguments that commands take at most
+	maxArgs = map[string]int{
+		"status":       3,
+		"limit":        3,
+		"mode":         1,
+		"discharge":    1,
+		"inhibit":      1,
+		"calibrate":    2,
+		"full":         2,
+		"profile":      1,
+		"schedule":     1,
+		"enforce":      1,
+		"health":       2,
+		"power":        1,
+		"grant":        1,
+		"earlyboot":    2,
+		"persist":      5,
+		"repair":       0,
+		"remove":       1,
+		"uninstall":    1,
+		"devices":      1,
+		"bugreport":    1,
+		"helper":       2,
+		"doctor":       0,
+		"completion":   1,
+		"__get":        1,
+		"__set":        2,
+		"__sleep-hook": 1,
+	}
 	events = [...]string{
 		"hibernate",
 		"hybrid-sleep",
@@ -36,273 +98,2562 @@ var (
 	unitfile string
 	//go:embed system-sleep.tmpl
 	sleepfile string
+	//go:embed persist-test.tmpl
+	testfile string
+	//go:embed grant.tmpl
+	grantfile string
+	//go:embed earlyboot.tmpl
+	earlybootfile string
+	//go:embed dracut-setup.tmpl
+	dracutfile string
+	//go:embed inhibit.tmpl
+	inhibitfile string
+	//go:embed calibrate-service.tmpl
+	calibrateservice string
+	//go:embed calibrate-timer.tmpl
+	calibratetimer string
+	//go:embed schedule-service.tmpl
+	scheduleservice string
+	//go:embed schedule-timer.tmpl
+	scheduletimer string
+	//go:embed enforce-service.tmpl
+	enforceservice string
+	//go:embed enforce-path.tmpl
+	enforcepath string
+	//go:embed sleep-hook.tmpl
+	sleephookservice string
+	//go:embed health-report.tmpl
+	reportfile string
+	//go:embed power-udev.tmpl
+	powerudev string
+	//go:embed reload-udev.tmpl
+	reloadudev string
+	//go:embed openrc.tmpl
+	openrcfile string
+	//go:embed runit-run.tmpl
+	runitfile string
+	//go:embed zzz-resume.tmpl
+	zzzfile string
+	//go:embed s6-up.tmpl
+	s6file string
+	//go:embed dinit.tmpl
+	dinitfile string
+	//go:embed cron.tmpl
+	cronfile string
+	//go:embed pm-sleep.tmpl
+	pmsleepfile string
+	//go:embed tlp.tmpl
+	tlpfile string
+	//go:embed bash-completion.tmpl
+	bashcompletion string
+	//go:embed zsh-completion.tmpl
+	zshcompletion string
+	//go:embed fish-completion.tmpl
+	fishcompletion string
 	//go:embed help.tmpl
 	helpmsg string
 	//go:embed version.tmpl
-	versionmsg string
-	batpath    string
-	bat        string
+	versionmsg    string
+	batpath       string
+	bat           string
+	thresholdpath string
+	// Persistence files of the current battery, see selectBattery
+	sleepfilename string
+	tlpfilename   string
+	tlpname       string
+	grantfilename string
+	initramfsname string
+	dracutmodule  string
+	testservice   string
+	testresult    string
+	// Full charge capacity before and after each calibration
+	calibrationlog string
+	// Settings kept by save for restore
+	savefile string
+	// Power source since its last change, and the udev rule for the changes
+	powerstate string
+	powerrule  string
+	// Udev rule that applies the limit again after a driver module reload
+	reloadrule string
+	// Output format version that scripts can pin with --stable-output
+	outputVersion = outputLatest
+	jsonOutput    bool
+	// Battery names given with -b/--battery
+	selection []string
+	// Files that readFile could not read since the last reset
+	readErrors []readError
+	// Whether --force was given, to overwrite unit files that bat did not write
+	force bool
+	// What to do when a desktop environment manages the threshold
+	managerPolicy string
+	// Whether --json or --text was given, over the output of the config file
+	formatGiven bool
+	// With --strict, status fails when a value is missing
+	strict bool
+	// With --no-redact, diagnostics keep the serial numbers and hostname
+	noRedact bool
+	// Whether errprint reported an error
+	failed bool
+	// Warnings about the current battery for the JSON output, see warn
+	warnings []string
 )
 
 func usage() {
-	fmt.Printf(helpmsg, version)
+	fmt.Printf(helpmsg, version, outputLatest)
 }
 
-func errexit(msg string) { // I:bat
-	fmt.Fprintf(os.Stderr, "[%s] Fatal: %s\n", bat, msg)
+func errexit(msg string) { // I:bat,config,jsonOutput
+	if jsonOutput {
+		data, _ := json.Marshal(map[string]any{"schema_version": schemaVersion, "battery": bat, "error": msg})
+		fmt.Fprintln(os.Stderr, string(data))
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "[%s] Fatal: %s\n", label(), msg)
 	os.Exit(1)
 }
 
+// Report an error that does not stop the other batteries, main exits
+// non-zero at the end
+func errprint(msg string) { // I:bat,config,jsonOutput
+	failed = true
+	if jsonOutput {
+		data, _ := json.Marshal(map[string]any{"schema_version": schemaVersion, "battery": bat, "error": msg})
+		fmt.Fprintln(os.Stderr, string(data))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[%s] Error: %s\n", label(), msg)
+}
+
+// Report a condition that is not an error: on stderr, or with --json in
+// the "warnings" of the output for the current battery
+func warn(msg string) { // I:bat,config,jsonOutput
+	if jsonOutput {
+		warnings = append(warnings, msg)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[%s] Warning: %s\n", label(), msg)
+}
+
+// Make the battery at path the current one, index is its position among
+// all batteries, for the battery names that tlp uses
+func selectBattery(path string, index int) {
+	batpath = path
+	bat = filepath.Base(path)
+	thresholdpath = filepath.Join(batpath, threshold)
+	driver = detectDriver()
+	sleepfilename = sleepdir + prefix + bat
+	tlpfilename = tlpdir + "50-" + prefix + bat + ".conf"
+	tlpname = fmt.Sprintf("BAT%d", index)
+	grantfilename = tmpfilesdir + prefix + bat + ".conf"
+	initramfsname = initramfsdir + prefix + bat
+	dracutmodule = dracutdir + "90" + prefix + bat + "/"
+	testservice = prefix + bat + "-test.service"
+	testresult = statedir + "persist-test-" + bat
+	calibrationlog = statedir + "calibration-" + bat
+	savefile = statedir + "saved-" + bat
+	powerstate = statedir + "power-" + bat
+	powerrule = udevdir + "90-" + prefix + bat + "-power.rules"
+	reloadrule = udevdir + "90-" + prefix + bat + "-reload.rules"
+}
+
+// Return the paths of the battery devices, Apple silicon Macs call theirs
+// macsmc-battery
+func findBatteries() []string {
+	batteries, _ := filepath.Glob(syspath + "BAT?")
+	apple, _ := filepath.Glob(syspath + "macsmc-battery")
+	return append(batteries, apple...)
+}
+
+// Name of a unit of the current battery, for what it does
+func unitName(purpose string) string { // I:bat
+	return prefix + bat + "-" + purpose + ".service"
+}
+
+// Name of the template unit that persists the limit of the current battery
+func unitTemplate() string { // I:bat
+	return prefix + bat + "@.service"
+}
+
+// Name of the instance of the template unit for event
+func eventUnit(event string) string { // I:bat
+	return prefix + bat + "@" + event + ".service"
+}
+
+// The templates take their values by position, so only fill them in here
+
+// The unit only writes the threshold files, so it gets sandboxed with just
+// those writable in /sys
+func renderUnit(shell string, start, limit int) string { // I:bat
+	command := strings.ReplaceAll(persistCommand(start, limit), `\`, `\\`) // systemd unescapes
+	writable := ""
+	if paths := unitPaths(); paths != nil {
+		writable = "ReadWritePaths=" + strings.Join(paths, " ") + "\n"
+	}
+	return fmt.Sprintf(unitfile, bat, limit, shell, command, writable)
+}
+
+func renderSleep(start, limit int) string { // I:bat
+	return fmt.Sprintf(sleepfile, bat, limit, persistCommand(start, limit))
+}
+
+func renderTest(shell string, limit int) string { // I:bat,testresult,testservice
+	path, value := persistWrite(-1, limit)
+	return fmt.Sprintf(testfile, bat, limit, eventUnit("multi-user"), shell, path, value, path,
+		testresult, testservice, services+testservice)
+}
+
+func renderInhibit(shell string, limit int) string { // I:bat,batpath
+	path, value := persistWrite(-1, limit)
+	charging := filepath.Join(batpath, behaviour)
+	return fmt.Sprintf(inhibitfile, bat, limit, shell, charging, path, value, path, charging)
+}
+
+// The start threshold only goes in when start is not -1
+func renderTLP(start, limit int) string { // I:bat,tlpname
+	startline := ""
+	if start >= 0 {
+		startline = fmt.Sprintf("START_CHARGE_THRESH_%s=%d\n", tlpname, start)
+	}
+	return fmt.Sprintf(tlpfile, bat, limit, startline, tlpname, limit)
+}
+
+func renderEarlyboot(limit int) string { // I:bat
+	path, value := persistWrite(-1, limit)
+	return fmt.Sprintf(earlybootfile, bat, limit, path, value, path)
+}
+
+func renderDracut() string { // I:bat
+	return fmt.Sprintf(dracutfile, bat, prefix+bat)
+}
+
+func renderGrant(group string, paths []string) string { // I:bat
+	var rules string
+	for _, path := range paths {
+		rules += fmt.Sprintf("z %s 0664 root %s -\n", path, group)
+	}
+	return fmt.Sprintf(grantfile, group, bat, rules)
+}
+
+// Remove the persistence files of bat up to v0.16, which only supported
+// a single battery, and the unit per event of the current battery that
+// later versions wrote instead of the template unit
+func removeLegacy() { // I:bat
+	removeFile(sleepdir + "chargelimit")
+	for _, event := range events {
+		for _, service := range []string{prefix + event + ".service", unitName(event)} {
+			content, err := os.ReadFile(services + service)
+			if err != nil || !ownUnit(string(content)) {
+				continue
+			}
+			stopUnit(service)
+			disableUnits(service)
+			removeFile(services + service)
+		}
+	}
+}
+
 func mustRead(variable string) string { // I:batpath
-	f, err := os.Open(filepath.Join(batpath, variable))
+	return readFile(filepath.Join(batpath, variable))
+}
+
+func readFile(path string) string { // I:readErrors
+	f, err := os.Open(path)
 	if err != nil {
+		recordReadError(path, err)
 		return ""
 	}
 	defer f.Close()
-	data := make([]byte, 32)
+	data := make([]byte, 4096) // sysfs values are at most a page
 	n, err := f.Read(data)
 	if err != nil && err != io.EOF {
+		recordReadError(path, err)
 		return ""
 	}
-	return string(data[:n-1])
+	return strings.TrimSuffix(string(data[:n]), "\n")
 }
 
-func main() {
-	maxArgs := 1
-	command := "status"
-	if len(os.Args) > 1 {
-		command = os.Args[1]
-		maxArgs = 2
+// Keep why path could not be read, for 'status -e'
+func recordReadError(path string, err error) {
+	var perr *os.PathError
+	if errors.As(err, &perr) {
+		err = perr.Err
 	}
-	switch command {
-	case "l", "limit", "-l", "--limit":
-		maxArgs = 3
+	for _, r := range readErrors {
+		if r.Path == path {
+			return
+		}
+	}
+	readErrors = append(readErrors, readError{path, err.Error()})
+}
+
+// Return the desktop service that manages the charge threshold, if any
+func desktopManager() string {
+	status, err := os.ReadFile(upowerstatus)
+	if err == nil && strings.TrimSpace(string(status)) == "1" {
+		return "GNOME (UPower)"
 	}
-	if len(os.Args) > maxArgs {
-		errexit("too many arguments")
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	for _, comm := range comms {
+		name, err := os.ReadFile(comm)
+		if err != nil || !strings.HasPrefix(string(name), "org_kde_powerde") {
+			continue
+		}
+		environ, err := os.ReadFile(filepath.Join(filepath.Dir(comm), "environ"))
+		if err != nil {
+			continue
+		}
+		for _, env := range strings.Split(string(environ), "\x00") {
+			if home, found := strings.CutPrefix(env, "HOME="); found && powerdevilManages(home) {
+				return "KDE PowerDevil"
+			}
+		}
 	}
+	return ""
+}
 
-	switch command {
-	case "h", "help", "-h", "--help":
-		usage()
-		os.Exit(0)
+// Return whether the PowerDevil config in home sets a charge threshold;
+// without one PowerDevil leaves the threshold alone
+func powerdevilManages(home string) bool {
+	rc, err := os.ReadFile(filepath.Join(home, ".config", "powerdevilrc"))
+	return err == nil && strings.Contains(string(rc), "BatteryChargeStopThreshold")
+}
 
-	case "V", "v", "version", "-V", "-v", "--version":
-		fmt.Printf(versionmsg, version, years)
+// Leave the threshold alone when a desktop environment manages it,
+// unless --takeover was given
+func checkManager() { // I:managerPolicy
+	manager := desktopManager()
+	if manager == "" || managerPolicy == "takeover" {
+		return
+	}
+	if managerPolicy == "defer" {
+		report("Charge limit is managed by "+manager+", leaving it alone", map[string]any{"managed": manager, "deferred": true})
 		os.Exit(0)
 	}
-	limit := ""
-	if len(command) > 0 && command[0] >= '0' && command[0] <= '9' {
-		limit = command
-		command = "limit"
+	errexit("charge limit is managed by " + manager + ", use --takeover to override or --defer to leave it")
+}
+
+// Return the limits the driver accepts, nil when any of 1-100 goes
+func supportedLimits() []int { // I:driver
+	return currentBackend().capabilities().limits
+}
+
+// Describe the supported limits, like "80, 100" or "10 to 100"
+func describeLimits(limits []int) string {
+	if n := len(limits); n > 2 && limits[n-1]-limits[0] == n-1 {
+		return fmt.Sprintf("%d to %d", limits[0], limits[n-1])
 	}
+	return strings.ReplaceAll(strings.Trim(fmt.Sprint(limits), "[]"), " ", ", ")
+}
 
-	batselect := os.Getenv("BAT_SELECT")
-	batglob := batselect
-	if len(batselect) != 4 || batselect[:3] != "BAT" {
-		batglob = "BAT?"
-		batselect = ""
+// Return the supported limit closest to limit, the lower one on a tie
+func nearestLimit(limit int, limits []int) int {
+	if limits == nil {
+		return limit
 	}
-	batteries, err := filepath.Glob(syspath + batglob)
-	if err != nil || len(batteries) == 0 {
-		bat = batglob
-		errexit("No battery device found")
+	nearest := limits[0]
+	for _, l := range limits[1:] {
+		d, n := l-limit, nearest-limit
+		if d*d < n*n {
+			nearest = l
+		}
 	}
+	return nearest
+}
 
-	// Ignoring any other batteries!
-	batpath = batteries[0]
-	bat = batpath[len(batpath)-4:]
-	if len(batteries) > 1 {
-		fmt.Printf("More than 1 battery device found:")
-		for _, battery := range batteries {
-			fmt.Printf(" %s", battery[len(battery)-4:])
-		}
-		fmt.Println("")
+// Explain a permission error, as root it means a security module denied it
+func denied() string {
+	if s := sandbox(); s != "" {
+		return sandboxed(s)
 	}
-	thresholdpath := filepath.Join(batpath, threshold)
-	switch command {
-	case "s", "status", "-s", "--status":
-		fmt.Printf("[%s]\n", bat)
-		fmt.Printf("Level: %s%%\n", mustRead("capacity"))
-		limit := mustRead(threshold)
-		if limit != "" {
-			fmt.Printf("Limit: %s%%\n", limit)
-		}
-		var health, full, design string
-		var ifull, idesign int
-		full = mustRead("charge_full")
-		if full == "" { // Try energy_full
-			full = mustRead("energy_full")
-			if full != "" {
-				design = mustRead("energy_full_design")
-			}
-		} else {
-			design = mustRead("charge_full_design")
-		}
-		if full != "" && design != "" {
-			ifull, err = strconv.Atoi(full)
-			if err == nil && ifull > 0 {
-				idesign, err = strconv.Atoi(design)
-				if err == nil && idesign > 0 {
-					health = fmt.Sprintf("%d", ifull*100/idesign)
-				}
-			}
-		}
-		if health != "" {
-			fmt.Printf("Health: %s%%\n", health)
-		} else {
-			fmt.Println("Health cannot be determined")
-		}
-		fmt.Printf("Status: %s\n", mustRead("status"))
-		if limit != "" {
-			disabled := false
-			for _, event := range events {
-				service := prefix + event + ".service"
-				output, _ := exec.Command("systemctl", "is-enabled", service).Output()
-				if string(output) != "enabled\n" {
-					disabled = true
-				}
-			}
-			_, err = os.Stat(sleepfilename)
-			if errors.Is(err, os.ErrNotExist) {
-fmt.Println("No sleepfile")
-				disabled = true
-			}
-			enabled := "yes"
-			if disabled {
-				enabled = "no"
-			}
-			fmt.Printf("Persist: %s\n", enabled)
-		} else {
-			fmt.Println("Charge limit is not supported")
+	if os.Geteuid() != 0 {
+		return "insufficient permissions, run with root privileges"
+	}
+	enforce, err := os.ReadFile("/sys/fs/selinux/enforce")
+	if err == nil && strings.TrimSpace(string(enforce)) == "1" {
+		return "denied by the SELinux policy, see: ausearch -m avc -ts recent"
+	}
+	enabled, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err == nil && strings.TrimSpace(string(enabled)) == "Y" {
+		label, _ := os.ReadFile("/proc/self/attr/current")
+		profile := strings.TrimSpace(strings.Trim(string(label), "\x00"))
+		if profile != "" && profile != "unconfined" {
+			return "denied by AppArmor profile '" + profile + "', see: journalctl -k | grep DENIED"
 		}
-	case "p", "persist", "-p", "--persist":
-		output, err := exec.Command("systemctl", "--version").CombinedOutput()
-		if err != nil {
-			errexit("cannot run 'systemctl --version'")
+	}
+	return "permission denied even with root privileges, check the kernel log for a denial"
+}
+
+// Write a system file owned by root with exactly mode and the SELinux label
+// that the policy expects, whatever the umask or the previous file was
+func writeSystemFile(file, content string, mode os.FileMode) error {
+	if dryRun {
+		plan("write " + file)
+		return nil
+	}
+	err := os.WriteFile(file, []byte(content), mode)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(file, mode)
+	if err != nil {
+		return err
+	}
+	err = os.Chown(file, 0, 0)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat("/sys/fs/selinux/enforce")
+	if err != nil { // No SELinux
+		return nil
+	}
+	restorecon, err := exec.LookPath("restorecon")
+	if err != nil {
+		return nil
+	}
+	return exec.Command(restorecon, file).Run()
+}
+
+// Return whether file already has content and mode, so writing it would
+// change nothing
+func unchanged(file, content string, mode os.FileMode) bool {
+	info, err := os.Stat(file)
+	if err != nil || info.Mode().Perm() != mode {
+		return false
+	}
+	old, err := os.ReadFile(file)
+	return err == nil && string(old) == content
+}
+
+// First line of the unit files that bat writes, followed by its version
+// and the limit when the unit sets one, see unitStamp
+const unitMarker = "# Written by bat v"
+
+// Error for a unit file that bat would overwrite but did not write
+var errForeign = errors.New("not written by bat")
+
+// Descriptions of the unit files of bat before they got the marker
+var legacyDescriptions = []string{"Persist battery ", "Pause battery ", "Check battery ", "Calibrate battery ",
+	"Check daily whether battery ", "Set the charge limit of battery "}
+
+// Return the first line of a unit file for limit, 0 for none
+func unitStamp(limit int) string {
+	if limit > 0 {
+		return fmt.Sprintf("%s%s for limit %d, changes get overwritten\n", unitMarker, version, limit)
+	}
+	return unitMarker + version + ", changes get overwritten\n"
+}
+
+// Return the version of bat and the limit from the first line of a unit
+// file, "" when there is no stamp and 0 when there is no limit
+func parseStamp(content string) (string, int) {
+	line, _, _ := strings.Cut(content, "\n")
+	stamp, found := strings.CutPrefix(line, unitMarker)
+	if !found {
+		return "", 0
+	}
+	stamp, _, _ = strings.Cut(stamp, ",")
+	v, limit, _ := strings.Cut(stamp, " for limit ")
+	ilimit, _ := strconv.Atoi(limit)
+	return v, ilimit
+}
+
+// Return whether bat wrote a unit file with content, going by the marker
+// or else the description
+func ownUnit(content string) bool {
+	if strings.HasPrefix(content, unitMarker) {
+		return true
+	}
+	for _, description := range legacyDescriptions {
+		if strings.HasPrefix(content, "[Unit]\nDescription="+description) {
+			return true
 		}
+	}
+	return false
+}
 
-		var version int
-		_, err = fmt.Sscanf(string(output), "systemd %d", &version)
+// Write a unit file with the stamp for limit, but not over one that bat
+// did not write, unless forced
+func writeUnitFile(file, content string, limit int) error { // I:force
+	old, err := os.ReadFile(file)
+	if err == nil && !force && !ownUnit(string(old)) {
+		return fmt.Errorf("'%s' was %w, to overwrite it use --force", file, errForeign)
+	}
+	return writeSystemFile(file, unitStamp(limit)+content, 0o644)
+}
+
+// Return whether another version of bat wrote the template unit of the
+// current battery, with the limit it sets
+func staleUnit() (bool, int) { // I:bat
+	content, err := os.ReadFile(services + unitTemplate())
+	if err != nil || !ownUnit(string(content)) {
+		return false, 0
+	}
+	v, limit := parseStamp(string(content))
+	if v == version {
+		return false, 0
+	}
+	if limit == 0 { // From before the stamp, like "charge limit of 80%"
+		_, after, _ := strings.Cut(string(content), "charge limit of ")
+		fmt.Sscanf(after, "%d%%", &limit)
+	}
+	return true, limit
+}
+
+// Return the initramfs tool of the system: dracut or initramfs-tools
+func initramfsTool() string {
+	_, err := exec.LookPath("dracut")
+	if err == nil {
+		return "dracut"
+	}
+	_, err = exec.LookPath("update-initramfs")
+	if err == nil {
+		return "initramfs-tools"
+	}
+	return ""
+}
+
+// Command that rebuilds the initramfs with tool
+func rebuildCommand(tool string) string {
+	if tool == "dracut" {
+		return "dracut -f"
+	}
+	return "update-initramfs -u"
+}
+
+// Install the hook that applies limit in early boot for tool and return
+// the path of the hook
+func installEarlyboot(tool string, limit int) (string, error) { // I:initramfsname,dracutmodule
+	if tool == "initramfs-tools" {
+		err := os.MkdirAll(initramfsdir, 0o755)
 		if err != nil {
-			errexit("cannot read version from 'systemctl --version'")
+			return initramfsname, err
 		}
+		return initramfsname, writeSystemFile(initramfsname, renderEarlyboot(limit), 0o755)
+	}
+	err := os.MkdirAll(dracutmodule, 0o755)
+	if err != nil {
+		return dracutmodule, err
+	}
+	err = writeSystemFile(dracutmodule+"module-setup.sh", renderDracut(), 0o755)
+	if err != nil {
+		return dracutmodule, err
+	}
+	return dracutmodule, writeSystemFile(dracutmodule+prefix+bat+".sh", renderEarlyboot(limit), 0o755)
+}
 
-		if version < 244 { // oneshot not implemented yet
-			errexit("systemd version 244-r1 or later required")
-		}
+// Remove the early boot hooks and return the tool of the initramfs that
+// still needs a rebuild, or "" when there were none
+func removeEarlyboot() string { // I:initramfsname,dracutmodule
+	tool := ""
+	if removeFile(initramfsname) == nil {
+		tool = "initramfs-tools"
+	}
+	_, err := os.Stat(dracutmodule)
+	if err == nil && removeAll(dracutmodule) == nil {
+		tool = "dracut"
+	}
+	return tool
+}
 
-		limit := mustRead(threshold)
-		if limit == "" {
-			errexit("cannot read current limit from '" + threshold + "'")
-		}
-		current, err := strconv.Atoi(limit)
-		if err != nil || current == 0 {
-			errexit("cannot convert '" + limit + "' to integer")
+// Install a unit that pauses the charging early in boot, until it has
+// applied the limit
+func installInhibit(shell string, current int) { // I:bat
+	service := unitName("inhibit")
+	file := services + service
+	err := writeUnitFile(file, renderInhibit(shell, current), current)
+	if err != nil {
+		if errors.Is(err, errForeign) {
+			errexit(err.Error())
 		}
+		errexit("could not create systemd unit file '" + file + "'")
+	}
+
+	err = enableUnits(service)
+	if err != nil {
+		errexit("could not enable systemd unit file '" + service + "'")
+	}
+}
+
+// Install a unit that checks the threshold once on the next boot
+func scheduleTest(shell string, current int) { // I:bat
+	err := makeDir(statedir, 0o755)
+	if err != nil {
+		errexit("could not create state directory '" + statedir + "'")
+	}
 
-		shell, err := exec.LookPath("sh")
-		if err != nil && !errors.Is(err, exec.ErrNotFound) { // Just set /bin/sh as shell
-			shell = "/bin/sh"
+	removeFile(testresult)
+	file := services + testservice
+	err = writeUnitFile(file, renderTest(shell, current), current)
+	if err != nil {
+		if errors.Is(err, errForeign) {
+			errexit(err.Error())
 		}
-		for _, event := range events {
-			service := prefix + event + ".service"
-			file := services + service
-			f, err := os.Create(file)
-			if err != nil {
-				if errors.Is(err, syscall.EACCES) {
-					errexit("insufficient permissions, run with root privileges")
-				}
+		errexit("could not create systemd unit file '" + file + "'")
+	}
 
-				errexit("could not create systemd unit file '" + file + "'")
-			}
+	err = enableUnits(testservice)
+	if err != nil {
+		errexit("could not enable systemd unit file '" + testservice + "'")
+	}
+}
 
-			defer f.Close()
-			_, err = f.WriteString(fmt.Sprintf(unitfile, bat, current, event, event, shell, current, thresholdpath, event))
-			if err != nil {
-				errexit("could not instantiate systemd unit file '" + service + "'")
-			}
+// Return the path of sh for the units
+func findShell() string {
+	shell, err := exec.LookPath("sh")
+	if err != nil && !errors.Is(err, exec.ErrNotFound) { // Just set /bin/sh as shell
+		shell = "/bin/sh"
+	}
+	return shell
+}
 
-			exec.Command("systemctl", "stop", service).Run()
-			err = exec.Command("systemctl", "start", service).Run()
-			if err != nil {
-				errexit("could not start systemd unit file '" + service + "'")
-			}
-			err = exec.Command("systemctl", "enable", service).Run()
-			if err != nil {
-				errexit("could not enable systemd unit file '" + service + "'")
-			}
-		}
-		f, err := os.Create(sleepfilename)
-		if err != nil {
-			errexit("could not create system-sleep file '" + sleepfilename + "'")
-		}
-		defer f.Close()
-		_, err = f.WriteString(fmt.Sprintf(sleepfile, bat, current, current, bat))
-		if err != nil {
-			errexit("could not instantiate system-sleep file '" + sleepfilename + "'")
+// Return the current thresholds as an argument to limit, like 80 or 75-80,
+// or "" when they cannot be read
+func limitArg() string { // I:driver
+	start, limit := getThresholds()
+	if limit == 0 {
+		return ""
+	}
+	if !hasStart() {
+		start = -1
+	}
+	return formatLimit(start, limit)
+}
+
+// Return thresholds as an argument to limit, the inverse of parseLimit
+func formatLimit(start, limit int) string {
+	if start >= 0 {
+		return fmt.Sprintf("%d-%d", start, limit)
+	}
+	return strconv.Itoa(limit)
+}
+
+// Start a transient timer that sets the limit back to value after duration,
+// replacing an earlier one
+func scheduleRevert(duration time.Duration, value string) error { // I:bat
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	unit := prefix + bat + "-revert"
+	stopUnit(unit + ".timer")
+	return exec.Command("systemd-run", "--unit="+unit, fmt.Sprintf("--on-active=%ds", int(duration.Seconds())),
+		"--timer-property=AccuracySec=1s", self, "--takeover", "-b", bat, "limit", value).Run()
+}
+
+// Start a transient service that waits for the battery to be full and then
+// sets the limit back to value, or after duration at the latest
+func scheduleRestore(duration time.Duration, value string) error { // I:bat,batpath
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	unit := prefix + bat + "-full"
+	stopUnit(unit) // Hitting RuntimeMaxSec fails it
+	status := filepath.Join(batpath, "status")
+	return exec.Command("systemd-run", "--unit="+unit, fmt.Sprintf("--property=RuntimeMaxSec=%d", int(duration.Seconds())),
+		"--property=ExecStopPost="+self+" --takeover -b "+bat+" limit "+value,
+		"sh", "-c", `until [ "$(cat `+status+`)" = Full ]; do sleep 60; done`).Run()
+}
+
+// Return the grants recorded in the state file as battery and group pairs
+func readGrants() [][2]string {
+	var grants [][2]string
+	content, _ := os.ReadFile(grantstate)
+	for _, line := range strings.Split(string(content), "\n") {
+		battery, group, found := strings.Cut(line, " ")
+		if found {
+			grants = append(grants, [2]string{battery, group})
 		}
+	}
+	return grants
+}
 
-		fmt.Printf("[%s] Persistence enabled for charge limit: %d\n", bat, current)
-	case "r", "remove", "-r", "--remove":
-		os.Remove(sleepfilename)
-		for _, event := range events {
-			service := prefix + event + ".service"
-			file := services + service
-			exec.Command("systemctl", "stop", service).Run()
-			output, err := exec.Command("systemctl", "disable", service).CombinedOutput()
-			if err != nil {
-				message := string(output)
-				switch true {
-				case strings.Contains(message, "does not exist"):
-					continue
-				case strings.Contains(message, "Access denied"):
-					errexit("insufficient permissions, run with root privileges")
-				default:
-					errexit("failure to disable unit file '" + service + "'")
-				}
-			}
-			err = os.Remove(file)
-			if err != nil && !errors.Is(err, syscall.ENOENT) {
-				errexit("failure to remove unit file '" + file + "'")
-			}
+// Replace the grant of battery in grants by group, or drop it when group
+// is empty, and return the content of the state file
+func updateGrants(grants [][2]string, battery, group string) string {
+	var content string
+	for _, grant := range grants {
+		if grant[0] != battery {
+			content += grant[0] + " " + grant[1] + "\n"
 		}
-		fmt.Printf("[%s] Persistence of charge limit removed\n", bat)
-	case "l", "limit", "-l", "--limit":
-		if limit == "" {
-			limit = os.Args[2]
-			if limit == "" {
-				errexit("Argument to 'limit' missing")
-			}
+	}
+	if group != "" {
+		content += battery + " " + group + "\n"
+	}
+	return content
+}
+
+// Record in the state file that group may change the limit of the battery,
+// or that nobody may when group is empty
+func recordGrant(group string) error { // I:bat
+	err := makeDir(statedir, 0o755)
+	if err != nil {
+		return err
+	}
+	content := updateGrants(readGrants(), bat, group)
+	if content == "" {
+		err = removeFile(grantstate)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
 		}
+		return err
+	}
+	if unchanged(grantstate, content, 0o644) {
+		return nil
+	}
+	return writeSystemFile(grantstate, content, 0o644)
+}
 
-		ilimit, err := strconv.Atoi(limit)
-		if err != nil || ilimit < 0 || ilimit > 100 {
-			errexit("argument to limit must be an integer between 0 and 100")
+// Remove the grant of the battery and give its files back to root, return
+// the group that had it or "" when there was none
+func revokeGrant() (string, error) { // I:bat,grantfilename
+	group := ""
+	for _, grant := range readGrants() {
+		if grant[0] == bat {
+			group = grant[1]
 		}
+	}
+	_, err := os.Stat(grantfilename)
+	if group == "" && err != nil {
+		return "", nil
+	}
+	if group == "" { // Rule from before grants were recorded
+		group = "unknown"
+	}
 
-		if ilimit == 0 {
-			ilimit = 100
+	err = removeFile(grantfilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	for _, path := range grantPaths() {
+		err = giveToRoot(path, 0o644)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
 		}
-		l := []byte(fmt.Sprintf("%d", ilimit))
-		err = os.WriteFile(thresholdpath, l, 0o644)
-		if err != nil {
-			if errors.Is(err, syscall.EACCES) {
-				errexit("insufficient permissions, run with root privileges")
-			}
+	}
+	return group, recordGrant("")
+}
 
-			errexit("could not set battery charge limit")
+// A file that could not be read and why
+type readError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Battery state as reported by status. With --json this is the output, so
+// changing the fields or their types needs a bump of schemaVersion.
+// Percentages are integers, 0 when unknown or unsupported.
+type batStatus struct {
+	SchemaVersion int      `json:"schema_version"`
+	Battery       string   `json:"battery"`
+	Level         int      `json:"level"`
+	Limit         int      `json:"limit"`
+	Start         int      `json:"start,omitempty"`
+	Health        int      `json:"health"`
+	Technology    string   `json:"technology,omitempty"`
+	Manufactured  string   `json:"manufactured,omitempty"`
+	AgeMonths     int      `json:"age_months,omitempty"`
+	Status        string   `json:"status"`
+	Persist       bool     `json:"persist"`
+	Sleephook     bool     `json:"sleep_hook"`
+	PersistTest   string   `json:"persist_test,omitempty"`
+	Managed       string   `json:"managed,omitempty"`
+	Sources       []string `json:"sources,omitempty"`
+	Mode          string   `json:"mode,omitempty"`
+	Behaviour     string   `json:"behaviour,omitempty"`
+	LowAlert      int      `json:"low_alert,omitempty"`
+	CriticalAlert int      `json:"critical_alert,omitempty"`
+	Alert         string   `json:"alert,omitempty"`
+	// Why persist cannot work, like "no systemd"
+	PersistUnavailable string `json:"persist_unavailable,omitempty"`
+	// Only with 'status -e'
+	ReadErrors []readError `json:"read_errors,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
+	// Only with 'status --design'
+	DesignLevel int `json:"design_level,omitempty"`
+	// Only with 'status --full'
+	PersistUnits map[string]string `json:"persist_units,omitempty"`
+	StaleUnits   []string          `json:"stale_units,omitempty"`
+	// Values that could not be read, for --strict
+	missing []string
+	// State of the persistence unit of each event
+	units map[string]string
+}
+
+func status() batStatus { // I:bat
+	readErrors = nil
+	st := batStatus{SchemaVersion: schemaVersion, Battery: bat, Status: mustRead("status")}
+	level, err := strconv.Atoi(mustRead("capacity"))
+	st.Level = level
+	if err != nil {
+		st.missing = append(st.missing, "level")
+	}
+	if st.Status == "" {
+		st.missing = append(st.missing, "status")
+	}
+	st.LowAlert, st.CriticalAlert = lowAlert, criticalAlert
+	if err == nil {
+		st.Alert = activeAlert(level, st.Status, lowAlert, criticalAlert)
+	}
+	_, st.Mode = chargeModes()
+	_, st.Behaviour = parseChoices(mustRead(behaviour))
+	start, limit := getThresholds()
+	st.Limit = limit
+	if start > 0 {
+		st.Start = start
+	}
+	var full, design string
+	full = mustRead("charge_full")
+	if full == "" { // Try energy_full
+		full = mustRead("energy_full")
+		if full != "" {
+			design = mustRead("energy_full_design")
+		}
+	} else {
+		design = mustRead("charge_full_design")
+	}
+	ifull, err := strconv.Atoi(full)
+	if err == nil && ifull > 0 {
+		idesign, err := strconv.Atoi(design)
+		if err == nil && idesign > 0 {
+			st.Health = ifull * 100 / idesign
 		}
+	}
+	if st.Health == 0 {
+		st.missing = append(st.missing, "health")
+	}
+	st.Technology = technology()
+	if made := manufactured(); !made.IsZero() {
+		st.Manufactured = made.Format("2006-01-02")
+		st.AgeMonths = ageMonths(made, time.Now())
+	}
+	if st.Limit == 0 {
+		st.missing = append(st.missing, "limit")
+		return st
+	}
 
-		if ilimit == 100 {
-			fmt.Printf("[%s] Charge limit unset\n", bat)
-		} else {
-			bselect := ""
-			if batselect != "" {
-				bselect = fmt.Sprintf("BAT_SELECT=%s ", batselect)
-			}
-			fmt.Printf("[%s] Charge limit set, to make it persist, run:\n%sbat persist\n", bat, bselect)
+	st.Managed = desktopManager()
+	st.Persist = true
+	st.units = map[string]string{}
+	systemd := preflight("systemd-running") == nil
+	for _, event := range events {
+		state := "unavailable"
+		if systemd {
+			state = unitState(eventUnit(event))
+		}
+		if state == "" || state == "not-found" {
+			state = "missing"
+		}
+		st.units[event] = state
+		if state != "enabled" {
+			st.Persist = false
+		}
+	}
+	_, err = os.Stat(sleepfilename)
+	st.Sleephook = !errors.Is(err, os.ErrNotExist)
+	if !st.Sleephook && systemd {
+		st.Sleephook = unitState(unitName("sleep-hook")) == "enabled"
+	}
+	if !st.Sleephook {
+		st.Persist = false
+	}
+	if !systemd {
+		st.PersistUnavailable = "no systemd"
+		if s := sandbox(); s != "" { // The sandbox may just hide it
+			st.PersistUnavailable = s + " sandbox"
+		}
+	}
+	for _, b := range inits {
+		if b.enabled() { // Persisted through another init system instead
+			hook := b.hook()
+			_, err = os.Stat(hook)
+			st.Sleephook = hook == "" || err == nil
+			st.Persist = st.Sleephook
+			st.PersistUnavailable = ""
+		}
+	}
+	result, err := os.ReadFile(testresult)
+	if err == nil {
+		st.PersistTest = strings.TrimSpace(string(result))
+	}
+	st.Sources = limitSources(st)
+	return st
+}
+
+// Return where the threshold of the current battery gets enforced from
+func limitSources(st batStatus) []string { // I:tlpfilename,tlpname
+	var sources []string
+	if st.Persist {
+		sources = append(sources, "bat persist")
+	}
+	_, err := os.Stat(tlpfilename)
+	if err == nil {
+		sources = append(sources, "bat persist --via-tlp")
+	}
+	configs, _ := filepath.Glob(tlpdir + "*.conf")
+	for _, config := range append([]string{"/etc/tlp.conf"}, configs...) {
+		if config == tlpfilename {
+			continue
+		}
+		data, err := os.ReadFile(config)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			name, _, found := strings.Cut(strings.TrimSpace(line), "=")
+			if found && strings.TrimSpace(name) == "STOP_CHARGE_THRESH_"+tlpname {
+				sources = append(sources, "TLP ("+config+")")
+				break
+			}
+		}
+	}
+	if st.Managed != "" {
+		sources = append(sources, st.Managed)
+	}
+	if sources == nil {
+		sources = []string{"manual write or unknown tool"}
+	}
+	return sources
+}
+
+func printJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		errexit("could not encode output as JSON")
+	}
+	fmt.Println(string(data))
+}
+
+// Report the outcome of a command: msg for humans, fields with --json
+func report(msg string, fields map[string]any) { // I:bat,config,jsonOutput
+	if !jsonOutput {
+		fmt.Printf("[%s] %s\n", label(), msg)
+		return
+	}
+	fields["schema_version"] = schemaVersion
+	fields["battery"] = bat
+	if warnings != nil {
+		fields["warnings"] = warnings
+	}
+	printJSON(fields)
+}
+
+// Strip the global options from args and return the rest
+func parseOptions(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-b", "--battery":
+			if i+1 == len(args) {
+				return nil, errors.New("Argument to '" + args[i] + "' missing")
+			}
+			i++
+			selection = append(selection, strings.Split(args[i], ",")...)
+		case "--json", "--text":
+			jsonOutput, formatGiven = args[i] == "--json", true
+		case "--strict":
+			strict = true
+		case "--force":
+			force = true
+		case "--no-redact":
+			noRedact = true
+		case "--takeover", "--defer":
+			managerPolicy = args[i][2:]
+		case "--stable-output":
+			if i+1 == len(args) {
+				return nil, errors.New("Argument to '--stable-output' missing")
+			}
+			i++
+			v, err := strconv.Atoi(strings.TrimPrefix(args[i], "v"))
+			if err != nil || v < 1 || v > outputLatest {
+				return nil, fmt.Errorf("output version must be between 1 and %d", outputLatest)
+			}
+			outputVersion = v
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return rest, nil
+}
+
+// Return the command that args ask for by any of its names, with its
+// arguments; a bare number is short for limit and no command for status
+func parseCommand(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "status", nil, nil
+	}
+	command, cargs := args[0], args[1:]
+	if command != "" && command[0] >= '0' && command[0] <= '9' {
+		command, cargs = "limit", args
+	}
+	if commands[command] != "" {
+		command = commands[command]
+	}
+	if len(cargs) > maxArgs[command] {
+		return command, nil, errors.New("too many arguments")
+	}
+	return command, cargs, nil
+}
+
+// Parse a limit argument, a percentage or a range like 75-80 of the start
+// and end threshold, with start -1 when not given
+func parseLimit(arg string) (int, int, error) {
+	first, last, isrange := strings.Cut(arg, "-")
+	if !isrange {
+		limit, err := strconv.Atoi(arg)
+		if err != nil || limit < 0 || limit > 100 {
+			return -1, 0, errors.New("argument to limit must be an integer between 0 and 100")
+		}
+		if limit == 0 {
+			limit = 100
+		}
+		return -1, limit, nil
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 || start > 99 {
+		return -1, 0, errors.New("start of the limit range must be an integer between 0 and 99")
+	}
+	limit, err := strconv.Atoi(last)
+	if err != nil || limit <= start || limit > 100 {
+		return -1, 0, errors.New("end of the limit range must be an integer above the start, up to 100")
+	}
+	return start, limit, nil
+}
+
+// Return the limit argument, the duration of '--for <duration>' in the
+// arguments to limit, 0 when it is not given, and whether --fix is given
+func parseLimitArgs(args []string) (string, time.Duration, bool, error) {
+	arg, duration, fix := "", time.Duration(0), false
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--fix" {
+			fix = true
+			continue
+		}
+		if args[i] != "--for" {
+			if arg != "" {
+				return "", 0, false, errors.New("too many arguments")
+			}
+			arg = args[i]
+			continue
+		}
+		if i+1 == len(args) {
+			return "", 0, false, errors.New("Argument to '--for' missing")
+		}
+		i++
+		d, err := parseFor(args[i])
+		if err != nil {
+			return "", 0, false, err
+		}
+		duration = d
+	}
+	if arg == "" {
+		return "", 0, false, errors.New("Argument to 'limit' missing")
+	}
+	return arg, duration, fix, nil
+}
+
+// Parse the argument to '--for', a duration of at least a minute
+func parseFor(arg string) (time.Duration, error) {
+	duration, err := time.ParseDuration(arg)
+	if err != nil || duration < time.Minute {
+		return 0, errors.New("argument to '--for' must be a duration of at least a minute, like: 2h or 90m")
+	}
+	return duration, nil
+}
+
+// Return duration like 2h or 1h30m, without the zero units at the end
+func shortDuration(duration time.Duration) string {
+	short := duration.Round(time.Second).String()
+	if strings.HasSuffix(short, "m0s") {
+		short = strings.TrimSuffix(short, "0s")
+	}
+	if strings.HasSuffix(short, "h0m") {
+		short = strings.TrimSuffix(short, "0m")
+	}
+	return short
+}
+
+// Split a list of battery names separated by commas or spaces
+func splitNames(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// Return the batteries among all that are named in selection, or all of them
+func selectBatteries(all, selection []string) ([]string, error) {
+	var batteries []string
+	for _, name := range selection {
+		found := false
+		for _, battery := range all {
+			if filepath.Base(battery) == name {
+				batteries = append(batteries, battery)
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, battery := range all {
+				names = append(names, filepath.Base(battery))
+			}
+			bat = name
+			return nil, errors.New("No such battery device, available: " + strings.Join(names, " "))
+		}
+	}
+	if batteries == nil {
+		return all, nil
+	}
+	return batteries, nil
+}
+
+func main() {
+	args, err := parseOptions(os.Args[1:])
+	if err != nil {
+		errexit(err.Error())
+	}
+	command, args, err := parseCommand(args)
+	if err != nil {
+		errexit(err.Error())
+	}
+	err = loadConfig()
+	if err != nil {
+		if !configless[command] {
+			errexit(err.Error())
+		}
+		warn(err.Error() + ", it gets ignored")
+	}
+	if !formatGiven {
+		jsonOutput = defaults["output"] == "json"
+	}
+
+	switch command {
+	case "help":
+		usage()
+		os.Exit(0)
+
+	case "version":
+		if jsonOutput {
+			printJSON(map[string]any{"schema_version": schemaVersion, "version": version})
+			os.Exit(0)
+		}
+		fmt.Printf(versionmsg, version, years)
+		os.Exit(0)
+
+	case "completion":
+		shell := "bash"
+		if len(args) > 0 {
+			shell = args[0]
+		}
+		if shell != "bash" && shell != "zsh" && shell != "fish" {
+			errexit("argument to completion must be bash, zsh or fish")
+		}
+		fmt.Print(renderCompletion(shell))
+		os.Exit(0)
+
+	case "devices":
+		batteries := findBatteries()
+		if len(batteries) == 0 {
+			bat = "BAT?"
+			errexit("No battery device found")
+		}
+		var devices []map[string]any
+		for _, battery := range batteries {
+			name := filepath.Base(battery)
+			level := readFile(filepath.Join(battery, "capacity"))
+			switch {
+			case len(args) > 0 && args[0] == "--plumbing":
+				fmt.Println(name)
+			case jsonOutput:
+				ilevel, _ := strconv.Atoi(level)
+				devices = append(devices, map[string]any{"battery": name, "level": ilevel})
+			default:
+				bat = name
+				fmt.Printf("[%s] Level: %s%%\n", label(), level)
+			}
+		}
+		if devices != nil {
+			printJSON(map[string]any{"schema_version": schemaVersion, "devices": devices})
+		}
+		os.Exit(0)
+
+	case "doctor":
+		findings := doctor()
+		if jsonOutput {
+			printJSON(map[string]any{"schema_version": schemaVersion, "findings": findings})
+			os.Exit(0)
+		}
+		fmt.Print(formatDoctor(findings))
+		os.Exit(0)
+
+	case "helper":
+		if len(args) == 0 {
+			group := helperGroup()
+			if jsonOutput {
+				printJSON(map[string]any{"schema_version": schemaVersion, "helper": group != "", "group": group})
+				os.Exit(0)
+			}
+			if group == "" {
+				fmt.Println("No helper installed")
+				os.Exit(0)
+			}
+			fmt.Printf("Helper %s lets group %s change the charge settings\n", helperfile, group)
+			os.Exit(0)
+		}
+		switch args[0] {
+		case "--remove":
+			if len(args) > 1 {
+				errexit("too many arguments")
+			}
+			err = os.Remove(helperfile)
+			if errors.Is(err, os.ErrNotExist) {
+				report("No helper was installed", map[string]any{"helper": false})
+				os.Exit(0)
+			}
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not remove '" + helperfile + "'")
+			}
+			report("Helper removed", map[string]any{"helper": false})
+		case "--install":
+			if len(args) == 1 {
+				errexit("Argument to '--install' missing")
+			}
+			group, err := user.LookupGroup(args[1])
+			if err != nil {
+				errexit("no such group '" + args[1] + "'")
+			}
+			err = installHelper(group)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit(err.Error())
+			}
+			report("Helper installed, group "+group.Name+" can change the charge settings through "+helperfile,
+				map[string]any{"helper": true, "group": group.Name})
+		default:
+			errexit("argument to helper can only be '--install' or '--remove'")
+		}
+		os.Exit(0)
+
+	case "bugreport":
+		path := bugreportfile
+		if len(args) > 0 {
+			path = args[0]
+		}
+		err = writeBugreport(path, collectBugreport())
+		if err != nil {
+			errexit("could not write the bug report to '" + path + "'")
+		}
+		if jsonOutput {
+			printJSON(map[string]any{"schema_version": schemaVersion, "bugreport": path})
+			os.Exit(0)
+		}
+		if noRedact {
+			fmt.Printf("Bug report written to %s, it includes the serial numbers and hostname\n", path)
+			os.Exit(0)
+		}
+		fmt.Printf("Bug report written to %s, serial numbers and hostname are redacted, check it before attaching it to an issue\n", path)
+		os.Exit(0)
+	}
+	all := findBatteries()
+	if len(all) == 0 {
+		bat = "BAT?"
+		if s := sandbox(); s != "" {
+			errexit("No battery device visible in the " + s + " sandbox, run on the host: " + hostCommand(s))
+		}
+		errexit("No battery device found")
+	}
+
+	if selection == nil { // Fall back on the older environment variable
+		selection = splitNames(os.Getenv("BAT_SELECT"))
+	}
+	if selection == nil {
+		selection = splitNames(defaults["battery"])
+	}
+	for i, name := range selection { // Display names work too
+		for device, display := range config["names"] {
+			if name == display {
+				selection[i] = device
+			}
+		}
+	}
+	batteries, err := selectBatteries(all, selection)
+	if err != nil {
+		errexit(err.Error())
+	}
+
+	if strings.HasPrefix(command, "__") && command != "__list-units" {
+		batteries = batteries[:1] // Plumbing output is for one battery
+	}
+	for _, battery := range batteries {
+		index := 0
+		for i, b := range all {
+			if b == battery {
+				index = i
+			}
+		}
+		selectBattery(battery, index)
+		run(command, args)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Run command with its arguments args on the current battery
+func run(command string, args []string) { // I:selection
+	warnings, planned = nil, nil
+	if s := sandbox(); s != "" && hostCommands[command] {
+		errexit(sandboxed(s))
+	}
+	switch command {
+	case "status":
+		details, full, design := false, false, false
+		for _, arg := range args {
+			switch arg {
+			case "-e", "--errors":
+				details = true
+			case "--full":
+				full = true
+			case "--design":
+				design = true
+			default:
+				errexit("argument to status can only be '-e', '--errors', '--full' or '--design'")
+			}
+		}
+		st := status()
+		if design { // Before the read errors, so they include its reads
+			st.DesignLevel = designLevel()
+		}
+		if details {
+			st.ReadErrors = readErrors
+		}
+		if full {
+			st.PersistUnits = st.units
+			if stale, _ := staleUnit(); stale {
+				st.StaleUnits = []string{unitTemplate()}
+			}
+		}
+		if st.Health > 100 {
+			warn(fmt.Sprintf("health of %d%% is above 100%%, the design capacity that the battery reports is off", st.Health))
+		}
+		st.Warnings = warnings
+		if strict && st.missing != nil { // After the output
+			defer errprint("missing " + strings.Join(st.missing, ", "))
+		}
+		if jsonOutput {
+			printJSON(st)
+			break
+		}
+		fmt.Printf("[%s]\n", label())
+		switch {
+		case st.DesignLevel > 0:
+			fmt.Printf("Level: %d%% (%d%% of design)\n", st.Level, st.DesignLevel)
+		case design:
+			fmt.Printf("Level: %d%% (design capacity unknown)\n", st.Level)
+		default:
+			fmt.Printf("Level: %d%%\n", st.Level)
+		}
+		if st.Limit > 0 {
+			fmt.Printf("Limit: %d%%\n", st.Limit)
+			if st.Start > 0 && outputVersion >= 2 {
+				fmt.Printf("Start: %d%%\n", st.Start)
+			}
+			if st.Managed != "" && outputVersion >= 2 {
+				fmt.Printf("Managed: %s\n", st.Managed)
+			}
+		}
+		switch {
+		case st.Health > 0 && st.Manufactured != "" && outputVersion >= 4:
+			fmt.Printf("Health: %d%%, age %s (made %s)\n", st.Health, formatAge(st.AgeMonths), st.Manufactured)
+		case st.Health > 0:
+			fmt.Printf("Health: %d%%\n", st.Health)
+		default:
+			fmt.Println("Health cannot be determined")
+		}
+		if st.Technology != "" && outputVersion >= 4 {
+			fmt.Printf("Technology: %s\n", st.Technology)
+		}
+		fmt.Printf("Status: %s\n", st.Status)
+		if st.Mode != "" && outputVersion >= 2 {
+			fmt.Printf("Mode: %s\n", st.Mode)
+		}
+		if st.Behaviour != "" && outputVersion >= 3 {
+			fmt.Printf("Behaviour: %s\n", st.Behaviour)
+		}
+		if (st.LowAlert > 0 || st.CriticalAlert > 0) && outputVersion >= 3 {
+			var alerts []string
+			if st.LowAlert > 0 {
+				alerts = append(alerts, fmt.Sprintf("low %d%%", st.LowAlert))
+			}
+			if st.CriticalAlert > 0 {
+				alerts = append(alerts, fmt.Sprintf("critical %d%%", st.CriticalAlert))
+			}
+			active := st.Alert
+			if active == "" {
+				active = "none"
+			}
+			fmt.Printf("Alerts: %s, active: %s\n", strings.Join(alerts, ", "), active)
+		}
+		if st.Limit > 0 {
+			if !st.Sleephook && st.PersistUnavailable == "" {
+				fmt.Println("No sleepfile")
+			}
+			enabled := "yes"
+			if !st.Persist {
+				enabled = "no"
+			}
+			if st.PersistUnavailable != "" {
+				enabled = "unavailable (" + st.PersistUnavailable + ")"
+			}
+			fmt.Printf("Persist: %s\n", enabled)
+			if st.PersistUnavailable != "" {
+				fmt.Printf("  to persist, run: %s\n", persistAlternative())
+			}
+			if full {
+				for _, event := range events {
+					fmt.Printf("  %s: %s\n", event, st.PersistUnits[event])
+				}
+				if st.StaleUnits != nil {
+					fmt.Printf("  written by another version of bat: %s, to regenerate it, run: bat repair\n",
+						strings.Join(st.StaleUnits, ", "))
+				}
+				hook := "present"
+				if !st.Sleephook {
+					hook = "missing"
+				}
+				fmt.Printf("  sleep hook: %s\n", hook)
+			}
+			if outputVersion >= 2 {
+				fmt.Printf("Source: %s\n", strings.Join(st.Sources, ", "))
+			}
+			if st.PersistTest != "" && outputVersion >= 2 {
+				fmt.Printf("Persist test: %s\n", st.PersistTest)
+			}
+		} else {
+			fmt.Println("Charge limit is not supported")
+		}
+		if details {
+			if st.ReadErrors == nil {
+				fmt.Println("Read errors: none")
+			}
+			for i, r := range st.ReadErrors {
+				if i == 0 {
+					fmt.Println("Read errors:")
+				}
+				fmt.Printf("  %s: %s\n", r.Path, r.Reason)
+			}
+		}
+	case "persist":
+		via, test, inhibit, logind := defaults["backend"], false, false, false
+		if b := detectInit(); via == "" && b != nil {
+			via = b.Name()
+		}
+		for _, arg := range args {
+			switch {
+			case strings.HasPrefix(arg, "--via-"):
+				via = strings.TrimPrefix(arg, "--via-")
+				if via != "tlp" && via != "systemd" && findInit(via) == nil {
+					errexit("backend of persist must be one of: " + strings.Join(persistBackends(), ", "))
+				}
+			case arg == "--cron":
+				via = "cron"
+			case arg == "--test":
+				test = true
+			case arg == "--inhibit-boot":
+				inhibit = true
+			case arg == "--logind":
+				logind = true
+			case arg == "--dry-run":
+				dryRun = true
+			default:
+				errexit("argument to persist can only be '--via-<backend>', '--cron', '--test', '--inhibit-boot', '--logind' or '--dry-run'")
+			}
+		}
+		if findInit(via) != nil && (test || inhibit) {
+			errexit("'--test' and '--inhibit-boot' need systemd")
+		}
+		if logind && (via == "tlp" || findInit(via) != nil) {
+			errexit("'--logind' needs systemd")
+		}
+		err := preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+		if inhibit && !hasBehaviour("inhibit-charge") {
+			errexit("pausing the charging is not supported by the driver")
+		}
+		if (test || inhibit) && viaTool() {
+			errexit("the driver works through a tool, '--test' and '--inhibit-boot' need a sysfs file")
+		}
+		start, current := getThresholds()
+		if !hasStart() {
+			start = -1
+		}
+		if current == 0 {
+			errexit("cannot read current limit")
+		}
+
+		checkManager()
+		shell := findShell()
+		if via == "tlp" {
+			tlp, err := exec.LookPath("tlp")
+			if err != nil {
+				errexit("cannot find 'tlp', is it installed?")
+			}
+			if unchanged(tlpfilename, renderTLP(start, current), 0o644) && !test && !inhibit {
+				report(fmt.Sprintf("Persistence through tlp for charge limit %d already up to date", current),
+					map[string]any{"limit": current, "persist": true, "backend": "tlp", "test": false, "inhibit_boot": false, "changed": false})
+				break
+			}
+			err = writeSystemFile(tlpfilename, renderTLP(start, current), 0o644)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+
+				errexit("could not create tlp drop-in file '" + tlpfilename + "'")
+			}
+
+			err = runCommand(tlp, "setcharge")
+			if err != nil {
+				errexit("could not apply the charge limit with 'tlp setcharge'")
+			}
+			if test {
+				scheduleTest(shell, current)
+			}
+			if inhibit {
+				installInhibit(shell, current)
+			}
+			if dryRun {
+				reportDryRun()
+				break
+			}
+			report(fmt.Sprintf("Persistence enabled through tlp for charge limit: %d", current),
+				map[string]any{"limit": current, "persist": true, "backend": "tlp", "test": test, "inhibit_boot": inhibit, "changed": true})
+			if test && !jsonOutput {
+				fmt.Printf("[%s] Charge limit will be checked on next boot, see 'bat status'\n", label())
+			}
+			break
+		}
+
+		if b := findInit(via); b != nil {
+			if b.upToDate(start, current) {
+				report(fmt.Sprintf("Persistence through %s for charge limit %d already up to date", via, current),
+					map[string]any{"limit": current, "persist": true, "backend": via, "test": false, "inhibit_boot": false, "changed": false})
+				break
+			}
+			err = b.install(start, current)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not install the " + via + " service '" + b.file() + "'")
+			}
+			if dryRun {
+				reportDryRun()
+				break
+			}
+			report(fmt.Sprintf("Persistence enabled through %s for charge limit: %d", via, current),
+				map[string]any{"limit": current, "persist": true, "backend": via, "test": false, "inhibit_boot": false, "changed": true})
+			break
+		}
+
+		if preflight("systemd-running") != nil {
+			errexit("no systemd, to persist, run: " + persistAlternative())
+		}
+		err = preflight("systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		// Only act on what differs from what is installed
+		removeLegacy()
+		file := services + unitTemplate()
+		content := renderUnit(shell, start, current)
+		changed := !unchanged(file, unitStamp(current)+content, 0o644)
+		if changed {
+			err = writeUnitFile(file, content, current)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				if errors.Is(err, errForeign) {
+					errexit(err.Error())
+				}
+
+				errexit("could not create systemd unit file '" + file + "'")
+			}
+			daemonReload()
+		}
+		var units []string
+		for _, event := range events {
+			service := eventUnit(event)
+			if !changed && unitState(service) == "enabled" {
+				continue
+			}
+			stopUnit(service)
+			err = startUnit(service)
+			if err != nil {
+				errexit("could not start systemd unit file '" + service + "'")
+			}
+			units = append(units, service)
+		}
+		if units != nil {
+			changed = true
+			err = enableUnits(units...)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not enable the systemd units " + strings.Join(units, ", "))
+			}
+		}
+		if logind {
+			if removeFile(sleepfilename) == nil {
+				changed = true
+			}
+			installed, err := installSleepHook(start, current)
+			if err != nil {
+				errexit("could not install the sleep hook service '" + unitName("sleep-hook") + "'")
+			}
+			changed = changed || installed
+		} else {
+			if removeSleepHook() {
+				changed = true
+			}
+			if !unchanged(sleepfilename, renderSleep(start, current), 0o755) {
+				changed = true
+				err = writeSystemFile(sleepfilename, renderSleep(start, current), 0o755)
+				if err != nil {
+					errexit("could not create system-sleep file '" + sleepfilename + "'")
+				}
+				info, err := os.Stat(sleepfilename)
+				if !dryRun && (err != nil || info.Mode().Perm() != 0o755) {
+					errexit("system-sleep file '" + sleepfilename + "' is not executable")
+				}
+			}
+		}
+		installed, err := installReloadRule()
+		if err != nil {
+			errexit("could not install the udev rule '" + reloadrule + "'")
+		}
+		changed = changed || installed || test || inhibit
+
+		if test {
+			scheduleTest(shell, current)
+		}
+		if inhibit {
+			installInhibit(shell, current)
+		}
+		if dryRun {
+			reportDryRun()
+			break
+		}
+		if !changed {
+			report(fmt.Sprintf("Persistence for charge limit %d already up to date", current),
+				map[string]any{"limit": current, "persist": true, "backend": "systemd", "test": false, "inhibit_boot": false, "changed": false})
+			break
+		}
+		report(fmt.Sprintf("Persistence enabled for charge limit: %d", current),
+			map[string]any{"limit": current, "persist": true, "backend": "systemd", "test": test, "inhibit_boot": inhibit, "changed": true})
+		if test && !jsonOutput {
+			fmt.Printf("[%s] Charge limit will be checked on next boot, see 'bat status'\n", label())
+		}
+	case "repair":
+		stale, limit := staleUnit()
+		if !stale {
+			report("No persistence units of another version of bat", map[string]any{"repaired": []string{}})
+			break
+		}
+		err := preflight("systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		start, current := getThresholds()
+		if limit == 0 {
+			limit = current
+		}
+		if !hasStart() || start >= limit {
+			start = -1
+		}
+		file := services + unitTemplate()
+		err = writeUnitFile(file, renderUnit(findShell(), start, limit), limit)
+		if err == nil {
+			_, err = os.Stat(sleepfilename)
+			if err == nil {
+				err = writeSystemFile(sleepfilename, renderSleep(start, limit), 0o755)
+			}
+		}
+		if _, statErr := os.Stat(services + unitName("sleep-hook")); err == nil && statErr == nil {
+			_, err = installSleepHook(start, limit)
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not regenerate systemd unit file '" + file + "'")
+		}
+		repaired := []string{unitTemplate()}
+		daemonReload()
+		report("Persistence units regenerated: "+strings.Join(repaired, ", "), map[string]any{"repaired": repaired})
+	case "enforce":
+		if len(args) > 0 && args[0] != "off" {
+			errexit("argument to enforce can only be 'off'")
+		}
+		if len(args) > 0 {
+			if !removeEnforce() {
+				report("The charge limit was not enforced", map[string]any{"enforce": false})
+				break
+			}
+			report("Enforcing of the charge limit stopped", map[string]any{"enforce": false})
+			break
+		}
+		err := preflight("kernel", "driver", "systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		if viaTool() {
+			errexit("the driver works through a tool, enforce needs a sysfs file")
+		}
+		start, current := getThresholds()
+		if !hasStart() {
+			start = -1
+		}
+		if current == 0 {
+			errexit("cannot read current limit")
+		}
+		err = installEnforce(findShell(), start, current)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			if errors.Is(err, errForeign) {
+				errexit(err.Error())
+			}
+			errexit("could not install the path unit '" + enforcePath() + "'")
+		}
+		report(fmt.Sprintf("Charge limit of %d enforced, see: journalctl -u %s", current, unitName("enforce")),
+			map[string]any{"enforce": true, "limit": current})
+	case "remove", "uninstall":
+		if len(args) > 0 && args[0] != "--dry-run" {
+			errexit("argument to " + command + " can only be '--dry-run'")
+		}
+		dryRun = len(args) > 0
+		removeLegacy()
+		removeFile(sleepfilename)
+		removeSleepHook()
+		removeFile(tlpfilename)
+		removeReloadRule()
+		for _, b := range inits {
+			b.remove()
+		}
+		stopUnit(testservice)
+		disableUnits(testservice)
+		removeFile(services + testservice)
+		removeFile(testresult)
+		stopUnit(unitName("inhibit"))
+		disableUnits(unitName("inhibit"))
+		removeFile(services + unitName("inhibit"))
+		defer daemonReload() // After all removals
+		for _, event := range events {
+			service := eventUnit(event)
+			stopUnit(service)
+			err := disableUnits(service)
+			if err != nil {
+				switch {
+				case errors.Is(err, errNoSystemd):
+					continue
+				case errors.Is(err, os.ErrNotExist):
+					continue
+				case errors.Is(err, os.ErrPermission):
+					errexit("insufficient permissions, run with root privileges")
+				default:
+					errexit("failure to disable unit file '" + service + "'")
+				}
+			}
+		}
+		file := services + unitTemplate()
+		err := removeFile(file)
+		if err != nil && !errors.Is(err, syscall.ENOENT) {
+			errexit("failure to remove unit file '" + file + "'")
+		}
+		if tool := removeEarlyboot(); tool != "" && !jsonOutput && !dryRun {
+			fmt.Printf("[%s] Early boot hook removed, to drop it from the initramfs, run:\n%s\n", label(), rebuildCommand(tool))
+		}
+		if command == "uninstall" {
+			unscheduleCalibration()
+			removeSchedule()
+			removeEnforce()
+			removePowerRule()
+			_, err = revokeGrant()
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not revoke the grant")
+			}
+		}
+		if dryRun {
+			daemonReload() // The deferred one would come after the report
+			reportDryRun()
+			break
+		}
+		if command != "uninstall" {
+			report("Persistence of charge limit removed", map[string]any{"persist": false})
+			break
+		}
+		report("Persistence and grant of charge limit removed", map[string]any{"persist": false, "group": ""})
+	case "enable":
+		err := preflight("systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		_, err = os.Stat(services + unitTemplate())
+		if err != nil {
+			errexit("no persistence units to enable, create them with: bat persist")
+		}
+		var enabled, missing []string
+		for _, event := range events {
+			service := eventUnit(event)
+			err := enableUnits(service)
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit("insufficient permissions, run with root privileges")
+				}
+				errexit("could not enable systemd unit file '" + service + "'")
+			}
+			enabled = append(enabled, service)
+		}
+		if _, err = os.Stat(services + unitName("sleep-hook")); err == nil {
+			err = enableUnits(unitName("sleep-hook"))
+			if err != nil {
+				errexit("could not enable systemd unit file '" + unitName("sleep-hook") + "'")
+			}
+			enabled = append(enabled, unitName("sleep-hook"))
+		} else if _, err = os.Stat(sleepfilename); err != nil {
+			missing = append(missing, sleepfilename)
+		}
+		if missing != nil {
+			warn("missing, run 'bat persist' to create them: " + strings.Join(missing, ", "))
+		}
+		report("Persistence units enabled: "+strings.Join(enabled, ", "), map[string]any{"enabled": enabled, "missing": missing})
+	case "revoke":
+		group, err := revokeGrant()
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not revoke the grant")
+		}
+		if group == "" {
+			report("No group could change the charge limit", map[string]any{"group": ""})
+			break
+		}
+		report("Group "+group+" can no longer change the charge limit", map[string]any{"group": "", "revoked": group})
+	case "limit":
+		if len(args) == 0 && defaults["limit"] != "" {
+			args = []string{defaults["limit"]}
+		}
+		arg, duration, fix, err := parseLimitArgs(args)
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		start, ilimit, err := parseLimit(arg)
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		err = preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		checkManager()
+		previous := ""
+		if duration > 0 {
+			previous = limitArg()
+			if previous == "" {
+				errexit("cannot read current limit to go back to")
+			}
+		}
+		requested := ilimit
+		if n := nearestLimit(ilimit, supportedLimits()); n != ilimit {
+			limits := strings.Trim(fmt.Sprint(supportedLimits()), "[]")
+			warn(fmt.Sprintf("the driver only accepts %s, using %d instead of %d", strings.ReplaceAll(limits, " ", ", "), n, ilimit))
+			ilimit = n
+		}
+		nostart := start >= 0 && !hasStart()
+		if kept := start; hasStart() {
+			if kept < 0 {
+				kept, _ = getThresholds()
+			}
+			if gap := minGap(); kept >= 0 && ilimit-kept < gap {
+				fitted := fitGap(kept, ilimit, gap)
+				if fix {
+					start = fitted
+					warn(fmt.Sprintf("start threshold lowered to %d, to stay %d below the limit", start, gap))
+				} else {
+					warn(fmt.Sprintf("the start threshold of %d is less than %d below the limit, the firmware may ignore it, use --fix to lower it to %d",
+						kept, gap, fitted))
+				}
+			}
+		}
+		err = setThresholds(start, ilimit)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+
+			errexit("could not set battery charge limit")
+		}
+
+		fields := map[string]any{"limit": ilimit}
+		if requested != ilimit {
+			fields["requested"] = requested
+		}
+		if start >= 0 && !nostart {
+			fields["start"] = start
+		}
+		if nostart {
+			warn("no start threshold on this battery, only the charge limit is used")
+		}
+		if duration > 0 {
+			err = scheduleRevert(duration, previous)
+			if err != nil {
+				errexit("could not schedule going back to limit " + previous + " with systemd-run")
+			}
+			fields["for"], fields["revert"] = shortDuration(duration), previous
+			report("Charge limit set for "+shortDuration(duration)+", then back to "+previous, fields)
+		} else if ilimit == 100 {
+			report("Charge limit unset", fields)
+		} else {
+			bselect := ""
+			if len(selection) > 0 {
+				bselect = "-b " + strings.Join(selection, ",") + " "
+			}
+			report("Charge limit set, to make it persist, run:\nbat "+bselect+"persist", fields)
+		}
+	case "full":
+		duration := 12 * time.Hour
+		if len(args) > 0 {
+			if args[0] != "--for" || len(args) == 1 {
+				errexit("argument to full can only be '--for <time>'")
+			}
+			var err error
+			duration, err = parseFor(args[1])
+			if err != nil {
+				errexit(err.Error())
+			}
+		}
+		err := preflight("kernel", "driver", "systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		previous := limitArg()
+		if previous == "" {
+			errexit("cannot read current limit")
+		}
+		if previous == "100" {
+			errexit("there is no charge limit to lift")
+		}
+
+		checkManager()
+		start, limit := getThresholds()
+		err = setThresholds(-1, 100)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not set battery charge limit")
+		}
+		err = scheduleRestore(duration, previous)
+		if err != nil {
+			setThresholds(start, limit) // Do not leave it lifted without a way back
+			errexit("could not schedule going back to limit " + previous + " with systemd-run")
+		}
+		report("Charging to full, the limit goes back to "+previous+" when full or in "+shortDuration(duration),
+			map[string]any{"limit": 100, "revert": previous, "for": shortDuration(duration)})
+	case "profile":
+		if len(args) == 0 {
+			var profiles []string
+			for _, name := range profileNames() {
+				profiles = append(profiles, name+" ("+config["profile."+name]["limit"]+")")
+			}
+			if jsonOutput {
+				printJSON(map[string]any{"schema_version": schemaVersion, "battery": bat, "profiles": profileNames()})
+				break
+			}
+			if profiles == nil {
+				fmt.Printf("[%s] No profiles in %s\n", label(), configfile)
+				break
+			}
+			fmt.Printf("[%s] Profiles: %s\n", label(), strings.Join(profiles, ", "))
+			break
+		}
+		profile := config["profile."+args[0]]
+		if profile == nil {
+			errexit("no profile '" + args[0] + "' in " + configfile)
+		}
+		start, ilimit, err := parseLimit(profile["limit"])
+		if err != nil {
+			errexit("profile " + args[0] + ": " + err.Error())
+		}
+		err = preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		checkManager()
+		if n := nearestLimit(ilimit, supportedLimits()); n != ilimit {
+			warn(fmt.Sprintf("the driver does not accept %d, using %d", ilimit, n))
+			ilimit = n
+		}
+		err = setThresholds(start, ilimit)
+		if err == nil && profile["mode"] != "" {
+			_, err = setChargeMode(profile["mode"])
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not apply profile " + args[0] + ": " + err.Error())
+		}
+		report(fmt.Sprintf("Profile %s applied, charge limit: %d", args[0], ilimit), map[string]any{"profile": args[0], "limit": ilimit})
+		// Persist the new limit the way the old one was
+		state := unitState(eventUnit("multi-user"))
+		_, err = os.Stat(tlpfilename)
+		switch {
+		case state == "enabled" && unitState(unitName("sleep-hook")) == "enabled":
+			run("persist", []string{"--via-systemd", "--logind"})
+		case state == "enabled":
+			run("persist", []string{"--via-systemd"})
+		case err == nil:
+			run("persist", []string{"--via-tlp"})
+		default:
+			for _, b := range inits {
+				if b.enabled() {
+					run("persist", []string{"--via-" + b.Name()})
+				}
+			}
+		}
+	case "health":
+		path := ""
+		if len(args) > 0 {
+			if args[0] != "--report" || len(args) == 1 {
+				errexit("argument to health can only be '--report <file>'")
+			}
+			path = args[1]
+		}
+		h := batteryHealth()
+		if path == "" {
+			h.Serial = redact(h.Serial)
+			if jsonOutput {
+				printJSON(h)
+				break
+			}
+			fmt.Printf("[%s]\n", label())
+			for _, line := range formatHealth(h) {
+				fmt.Println(line)
+			}
+			for _, p := range h.History {
+				fmt.Printf("Calibrated %s: %s\n", p.Date.Format("2006-01-02"), formatCapacity(p.Full, h.Energy))
+			}
+			break
+		}
+		if len(findBatteries()) > 1 && len(selection) != 1 { // A report per battery
+			ext := filepath.Ext(path)
+			path = strings.TrimSuffix(path, ext) + "-" + bat + ext
+		}
+		machine := strings.TrimSpace(readFile(dmivendor) + " " + readFile("/sys/class/dmi/id/product_name"))
+		err := os.WriteFile(path, []byte(redact(renderReport(h, machine, time.Now()))), 0o644)
+		if err != nil {
+			errexit("could not write the report to '" + path + "'")
+		}
+		message := "Health report written to " + path + ", the serial number is redacted"
+		if noRedact {
+			message = "Health report written to " + path + ", it includes the serial number"
+		}
+		report(message, map[string]any{"report": path})
+	case "check":
+		if !(sysfsBackend{}).detect() {
+			errexit("no '" + threshold + "' to check")
+		}
+		_, limit := sysfsBackend{}.get()
+		firmware := firmwareLimits()
+		if len(firmware) == 0 {
+			report(fmt.Sprintf("Limit in sysfs: %d%%, no firmware-side value to compare with", limit),
+				map[string]any{"sysfs": limit, "firmware": firmware})
+			break
+		}
+		var values []string
+		match := true
+		for name, value := range firmware {
+			values = append(values, fmt.Sprintf("%s: %d%%", name, value))
+			if value != limit {
+				match = false
+			}
+		}
+		sort.Strings(values)
+		if !match { // After the output
+			defer errprint("the limit in sysfs does not match the firmware, something else changed one of them")
+		}
+		report(fmt.Sprintf("Limit in sysfs: %d%%, in the embedded controller through %s", limit, strings.Join(values, ", ")),
+			map[string]any{"sysfs": limit, "firmware": firmware, "match": match})
+	case "save":
+		settings := currentSettings()
+		if settings["limit"] == "" {
+			errexit("cannot read current limit")
+		}
+		err := saveSettings(settings)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not save the settings in '" + savefile + "'")
+		}
+		report("Settings saved, to apply them again, run:\nbat restore", map[string]any{"saved": settings})
+	case "restore":
+		err := preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		checkManager()
+		settings, err := restoreSettings()
+		if err != nil {
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				errexit("no saved settings, save them first with: bat save")
+			case errors.Is(err, os.ErrPermission):
+				errexit(denied())
+			}
+			errexit("could not restore the settings: " + err.Error())
+		}
+		report("Settings restored: "+strings.ReplaceAll(strings.TrimSpace(formatSettings(settings)), "\n", ", "), map[string]any{"restored": settings})
+	case "mode":
+		modes, current := chargeModes()
+		if modes == nil {
+			errexit("charge modes are not supported by the driver")
+		}
+		if len(args) == 0 {
+			if jsonOutput {
+				printJSON(map[string]any{"schema_version": schemaVersion, "battery": bat, "mode": current, "modes": modes})
+				break
+			}
+			fmt.Printf("[%s] Mode: %s\nAvailable: %s\n", label(), current, strings.Join(modes, ", "))
+			break
+		}
+
+		err := preflight("kernel")
+		if err != nil {
+			errexit(err.Error())
+		}
+		current, err = setChargeMode(args[0])
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit(err.Error())
+		}
+		report("Charge mode set to "+current, map[string]any{"mode": current})
+	case "discharge", "inhibit":
+		if len(args) == 0 || args[0] != "on" && args[0] != "off" {
+			errexit("argument to " + command + " must be 'on' or 'off'")
+		}
+		err := preflight("kernel")
+		if err != nil {
+			errexit(err.Error())
+		}
+		on, action := "force-discharge", "Forced discharging"
+		if command == "inhibit" {
+			on, action = "inhibit-charge", "Pausing the charging"
+		}
+		value := "auto"
+		if args[0] == "on" {
+			value = on
+		}
+		err = setBehaviour(value)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit(err.Error())
+		}
+		if command == "discharge" && value == "auto" {
+			stopUnit(prefix + bat + "-discharge")
+		}
+		if value == "auto" {
+			report(action+" stopped", map[string]any{"behaviour": value})
+			break
+		}
+		if command == "discharge" && inhibitDischargeSleep() != nil {
+			warn("could not start " + prefix + bat + "-discharge to keep the laptop from sleeping while discharging")
+		}
+		report(action+" started, even on AC, to stop it, run:\nbat "+command+" off", map[string]any{"behaviour": value})
+	case "schedule":
+		if len(args) > 0 && args[0] != "off" {
+			errexit("argument to schedule can only be 'off'")
+		}
+		if len(args) > 0 {
+			if removeSchedule() == 0 {
+				report("No limits were scheduled", map[string]any{"schedule": map[string]string{}})
+				break
+			}
+			report("Scheduled limits removed", map[string]any{"schedule": map[string]string{}})
+			break
+		}
+		entries, _ := parseSchedule(config["schedule"]) // Checked by loadConfig
+		if entries == nil {
+			errexit("no [schedule] section with limits in " + configfile)
+		}
+		err := preflight("kernel", "driver", "systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		err = checkCalendars(entries)
+		if err != nil {
+			errexit(err.Error())
+		}
+		err = installSchedule(entries)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			if errors.Is(err, errForeign) {
+				errexit(err.Error())
+			}
+			errexit("could not install the schedule timers")
+		}
+		var times []string
+		schedule := map[string]string{}
+		for _, entry := range entries {
+			times = append(times, entry.calendar+": "+entry.limit)
+			schedule[entry.calendar] = entry.limit
+		}
+		report("Limits scheduled, "+strings.Join(times, ", ")+", see: systemctl list-timers '"+prefix+bat+"-schedule-*'",
+			map[string]any{"schedule": schedule})
+	case "power":
+		if len(args) > 0 && args[0] != "--install" && args[0] != "--remove" {
+			errexit("argument to power can only be '--install' or '--remove'")
+		}
+		if len(args) > 0 && args[0] == "--remove" {
+			if !removePowerRule() {
+				report("No power rules were installed", map[string]any{"power": false})
+				break
+			}
+			report("Power rules removed", map[string]any{"power": false})
+			break
+		}
+		rules, _ := parsePowerRules(config["power"]) // Checked by loadConfig
+		if rules == nil {
+			errexit("no [power] section with limits in " + configfile)
+		}
+		err := preflight("kernel", "driver", "systemd")
+		if err != nil {
+			errexit(err.Error())
+		}
+		if len(args) > 0 {
+			err = installPowerRule()
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not install the udev rule '" + powerrule + "'")
+			}
+			if !jsonOutput {
+				fmt.Printf("[%s] Power rules installed, they apply on every change of the power source (%s)\n", label(), powerrule)
+			}
+		}
+		source := powerSource()
+		recorded, since := powerSince()
+		if recorded != source {
+			err = recordPower(source)
+			if err == nil {
+				err = schedulePower(rules, source)
+			}
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				errexit("could not start the timers of the power rules")
+			}
+			since = time.Now()
+		}
+		limit := powerLimit(rules, source, time.Since(since))
+		if limit == "" {
+			report("No power rule applies on "+source+" yet", map[string]any{"source": source, "limit": ""})
+			break
+		}
+		if managerPolicy == "" { // The power rules are set up on purpose, unlike a manual limit
+			managerPolicy = "takeover"
+		}
+		run("limit", []string{limit})
+	case "calibrate":
+		if len(args) > 0 && args[0] != "--schedule" && args[0] != "--if-due" {
+			errexit("argument to calibrate can only be '--schedule' or '--if-due'")
+		}
+		if len(args) == 1 {
+			errexit("Argument to '" + args[0] + "' missing")
+		}
+		if len(args) > 0 && args[0] == "--schedule" && args[1] == "off" {
+			if !unscheduleCalibration() {
+				report("No calibration was scheduled", map[string]any{"schedule": ""})
+				break
+			}
+			report("Scheduled calibration removed", map[string]any{"schedule": ""})
+			break
+		}
+		var interval time.Duration
+		if len(args) > 0 {
+			var err error
+			interval, err = parseInterval(args[1])
+			if err != nil {
+				errexit(err.Error())
+			}
+		}
+		if len(args) > 0 && args[0] == "--if-due" {
+			if last := lastCalibration(); time.Since(last) < interval {
+				report("Calibration not due yet, last one was on "+last.Format("2006-01-02"), map[string]any{"due": false})
+				break
+			}
+		}
+		checks := []string{"kernel", "driver"}
+		if len(args) > 0 && args[0] == "--schedule" {
+			checks = append(checks, "systemd")
+		}
+		err := preflight(checks...)
+		if err != nil {
+			errexit(err.Error())
+		}
+		// Without force-discharge the calibration waits for someone to unplug
+		// the charger, a timer run would hold the battery at full forever
+		if len(args) > 0 && !hasBehaviour("force-discharge") {
+			errexit("the driver cannot force-discharge the battery, calibrate needs someone to unplug the charger, so it cannot run unattended")
+		}
+		if len(args) > 0 && args[0] == "--schedule" {
+			err = scheduleCalibration(args[1])
+			if err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					errexit(denied())
+				}
+				if errors.Is(err, errForeign) {
+					errexit(err.Error())
+				}
+				errexit("could not install the calibration timer")
+			}
+			report("Calibration scheduled when the last one is "+args[1]+" ago, see: journalctl -u "+unitName("calibrate"),
+				map[string]any{"schedule": args[1]})
+			break
+		}
+		_, current := getThresholds()
+		if current == 0 {
+			errexit("cannot read current limit")
+		}
+		if mustRead("capacity") == "" {
+			errexit("cannot read the charge level")
+		}
+
+		checkManager()
+		if !jsonOutput {
+			fmt.Printf("[%s] Calibrating, this takes a full charge, discharge and charge, the limit goes back to %d after\n", label(), current)
+		}
+		before, after, err := calibrate()
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("calibration failed: " + err.Error())
+		}
+		report(fmt.Sprintf("Calibration done, full charge capacity before: %d, after: %d (logged in %s)", before, after, calibrationlog),
+			map[string]any{"limit": current, "before": before, "after": after})
+	case "grant":
+		if len(args) == 0 {
+			errexit("Argument to 'grant' missing")
+		}
+		if args[0] == "--list" {
+			group := ""
+			for _, grant := range readGrants() {
+				if grant[0] == bat {
+					group = grant[1]
+				}
+			}
+			if jsonOutput {
+				printJSON(map[string]any{"schema_version": schemaVersion, "battery": bat, "group": group})
+				break
+			}
+			if group == "" {
+				fmt.Printf("[%s] No group can change the charge limit\n", label())
+				break
+			}
+			fmt.Printf("[%s] Group %s can change the charge limit (%s)\n", label(), group, grantfilename)
+			break
+		}
+		group, err := user.LookupGroup(args[0])
+		if err != nil {
+			errexit("no such group '" + args[0] + "'")
+		}
+		gid, _ := strconv.Atoi(group.Gid)
+		err = preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+
+		paths := grantPaths()
+		if paths == nil {
+			errexit("the driver works through a tool that only root can run")
+		}
+		err = writeSystemFile(grantfilename, renderGrant(group.Name, paths), 0o644)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not create tmpfiles.d file '" + grantfilename + "'")
+		}
+		for _, path := range paths { // Now, the rule applies from the next boot
+			err = os.Chown(path, 0, gid)
+			if err == nil {
+				err = os.Chmod(path, 0o664)
+			}
+			if err != nil {
+				errexit("could not change the permissions of '" + path + "'")
+			}
+		}
+		err = recordGrant(group.Name)
+		if err != nil {
+			errexit("could not record the grant in '" + grantstate + "'")
+		}
+		report("Group "+group.Name+" can now change the charge limit",
+			map[string]any{"group": group.Name, "files": paths})
+	case "earlyboot":
+		if len(args) == 0 {
+			installed := ""
+			for _, path := range []string{initramfsname, dracutmodule} {
+				_, err := os.Stat(path)
+				if err == nil {
+					installed = path
+				}
+			}
+			if jsonOutput {
+				printJSON(map[string]any{"schema_version": schemaVersion, "battery": bat, "earlyboot": installed})
+				break
+			}
+			if installed == "" {
+				fmt.Printf("[%s] Early boot hook: not installed\n", label())
+				break
+			}
+			fmt.Printf("[%s] Early boot hook: %s\n", label(), installed)
+			break
+		}
+		if args[0] != "--generate" {
+			errexit("argument to earlyboot can only be '--generate'")
+		}
+		tool := initramfsTool()
+		if len(args) > 1 {
+			tool = args[1]
+		}
+		if tool != "dracut" && tool != "initramfs-tools" {
+			errexit("no dracut or initramfs-tools found, give one after '--generate'")
+		}
+		err := preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+		if viaTool() {
+			errexit("the driver works through a tool, which is not in the initramfs")
+		}
+		_, current := getThresholds()
+		if current == 0 {
+			errexit("cannot read current limit")
+		}
+
+		checkManager()
+		path, err := installEarlyboot(tool, current)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not create early boot hook '" + path + "'")
+		}
+		report(fmt.Sprintf("Early boot hook for charge limit %d created in %s, to include it, run:\n%s",
+			current, path, rebuildCommand(tool)), map[string]any{"limit": current, "earlyboot": path, "tool": tool})
+	case "__get":
+		if len(args) == 0 || plumbing[args[0]] == "" {
+			errexit("argument to '__get' must be threshold, level, status or behaviour")
+		}
+		value := mustRead(plumbing[args[0]])
+		if args[0] == "threshold" {
+			_, limit := getThresholds()
+			value = strconv.Itoa(limit)
+		}
+		if value == "" || value == "0" && args[0] == "threshold" {
+			errexit("cannot read '" + plumbing[args[0]] + "'")
+		}
+		fmt.Println(value)
+	case "__set":
+		if len(args) < 2 || args[0] != "threshold" {
+			errexit("usage: __set threshold <int>")
+		}
+		ilimit, err := strconv.Atoi(args[1])
+		if err != nil || ilimit < 1 || ilimit > 100 {
+			errexit("threshold must be an integer between 1 and 100")
+		}
+		err = preflight("kernel", "driver")
+		if err != nil {
+			errexit(err.Error())
+		}
+		if limits := supportedLimits(); nearestLimit(ilimit, limits) != ilimit { // Plumbing does not round
+			errexit("the driver only accepts " + describeLimits(limits))
+		}
+		err = setThresholds(-1, ilimit)
+		if err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				errexit(denied())
+			}
+			errexit("could not write the threshold")
+		}
+	case "__sleep-hook":
+		if len(args) == 0 {
+			errexit("usage: __sleep-hook <limit>")
+		}
+		start, ilimit, err := parseLimit(args[0])
+		if err != nil {
+			errexit(err.Error())
+		}
+		err = preflight("kernel", "driver")
+		if err == nil {
+			err = sleepHook(start, ilimit)
+		}
+		if err != nil {
+			errexit(err.Error())
+		}
+	case "__list-units":
+		for _, event := range events {
+			service := eventUnit(event)
+			state := unitState(service)
+			if state == "" {
+				state = "missing"
+			}
+			fmt.Printf("%s\t%s\n", service, state)
+		}
+	case "__profiles": // For shell completion
+		fmt.Println(strings.Join(profileNames(), " "))
+	case "__modes": // For shell completion
+		modes, _ := chargeModes()
+		fmt.Println(strings.Join(modes, " "))
+	case "__limits": // For shell completion
+		limits := supportedLimits()
+		if limits == nil {
+			limits = []int{50, 60, 70, 80, 90, 100}
+		}
+		values := []string{"0"}
+		for _, l := range limits {
+			values = append(values, strconv.Itoa(l))
 		}
+		fmt.Println(strings.Join(values, " "))
 	default:
 		usage()
 		errexit("argument '" + command + "' invalid")
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		args    []string
+		command string
+		cargs   []string
+		fails   bool
+	}{
+		{nil, "status", nil, false},
+		{[]string{"s"}, "status", []string{}, false},
+		{[]string{"-s"}, "status", []string{}, false},
+		{[]string{"--status"}, "status", []string{}, false},
+		{[]string{"status", "-e"}, "status", []string{"-e"}, false},
+		{[]string{"status", "-e", "--full"}, "status", []string{"-e", "--full"}, false},
+		{[]string{"status", "-e", "--full", "--design", "now"}, "status", nil, true},
+		{[]string{"80"}, "limit", []string{"80"}, false},
+		{[]string{"0"}, "limit", []string{"0"}, false},
+		{[]string{"80", "90"}, "limit", []string{"80", "90"}, false}, // parseLimitArgs fails
+		{[]string{"l", "60"}, "limit", []string{"60"}, false},
+		{[]string{"--limit", "60"}, "limit", []string{"60"}, false},
+		{[]string{"limit", "100", "--for", "2h"}, "limit", []string{"100", "--for", "2h"}, false},
+		{[]string{"limit", "60", "--for", "2h", "70"}, "limit", nil, true},
+		{[]string{"p"}, "persist", []string{}, false},
+		{[]string{"persist", "--via-tlp", "--test"}, "persist", []string{"--via-tlp", "--test"}, false},
+		{[]string{"-r"}, "remove", []string{}, false},
+		{[]string{"remove", "--dry-run"}, "remove", []string{"--dry-run"}, false},
+		{[]string{"remove", "--dry-run", "all"}, "remove", nil, true},
+		{[]string{"uninstall", "--dry-run"}, "uninstall", []string{"--dry-run"}, false},
+		{[]string{"uninstall", "--dry-run", "all"}, "uninstall", nil, true},
+		{[]string{"h"}, "help", []string{}, false},
+		{[]string{"V"}, "version", []string{}, false},
+		{[]string{"-v"}, "version", []string{}, false},
+		{[]string{"completion", "zsh"}, "completion", []string{"zsh"}, false},
+		{[]string{"devices", "--plumbing"}, "devices", []string{"--plumbing"}, false},
+		{[]string{"discharge", "on"}, "discharge", []string{"on"}, false},
+		{[]string{"discharge", "on", "now"}, "discharge", nil, true},
+		{[]string{"doctor"}, "doctor", []string{}, false},
+		{[]string{"doctor", "BAT0"}, "doctor", nil, true},
+		{[]string{"__set", "threshold", "80"}, "__set", []string{"threshold", "80"}, false},
+		{[]string{"__list-units", "x"}, "__list-units", nil, true},
+		{[]string{"bogus"}, "bogus", []string{}, false},
+		{[]string{""}, "", []string{}, false},
+	}
+	for _, test := range tests {
+		command, cargs, err := parseCommand(test.args)
+		if (err != nil) != test.fails {
+			t.Errorf("parseCommand(%q) error: %v, want failure: %v", test.args, err, test.fails)
+			continue
+		}
+		if command != test.command || !test.fails && !reflect.DeepEqual(cargs, test.cargs) {
+			t.Errorf("parseCommand(%q) = %q %q, want %q %q", test.args, command, cargs, test.command, test.cargs)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		args      []string
+		rest      []string
+		selection []string
+		json      bool
+		output    int
+		fails     bool
+	}{
+		{[]string{"status"}, []string{"status"}, nil, false, outputLatest, false},
+		{[]string{"-b", "BAT1", "status"}, []string{"status"}, []string{"BAT1"}, false, outputLatest, false},
+		{[]string{"--battery", "BAT0,BAT1", "80"}, []string{"80"}, []string{"BAT0", "BAT1"}, false, outputLatest, false},
+		{[]string{"-b", "BAT0", "-b", "BAT1"}, nil, []string{"BAT0", "BAT1"}, false, outputLatest, false},
+		{[]string{"persist", "--json"}, []string{"persist"}, nil, true, outputLatest, false},
+		{[]string{"--json", "--text"}, nil, nil, false, outputLatest, false},
+		{[]string{"--strict", "status"}, []string{"status"}, nil, false, outputLatest, false},
+		{[]string{"bugreport", "--no-redact"}, []string{"bugreport"}, nil, false, outputLatest, false},
+		{[]string{"persist", "--force"}, []string{"persist"}, nil, false, outputLatest, false},
+		{[]string{"--stable-output", "v1"}, nil, nil, false, 1, false},
+		{[]string{"--stable-output", "1", "s"}, []string{"s"}, nil, false, 1, false},
+		{[]string{"--stable-output", "99"}, nil, nil, false, outputLatest, true},
+		{[]string{"--stable-output"}, nil, nil, false, outputLatest, true},
+		{[]string{"-b"}, nil, nil, false, outputLatest, true},
+	}
+	for _, test := range tests {
+		selection, jsonOutput, outputVersion = nil, false, outputLatest
+		rest, err := parseOptions(test.args)
+		if (err != nil) != test.fails {
+			t.Errorf("parseOptions(%q) error: %v, want failure: %v", test.args, err, test.fails)
+			continue
+		}
+		if test.fails {
+			continue
+		}
+		if !reflect.DeepEqual(rest, test.rest) || !reflect.DeepEqual(selection, test.selection) ||
+			jsonOutput != test.json || outputVersion != test.output {
+			t.Errorf("parseOptions(%q) = %q, selection %q, json %v, output %d", test.args, rest, selection, jsonOutput, outputVersion)
+		}
+	}
+	selection, jsonOutput, outputVersion, strict, noRedact, formatGiven, force = nil, false, outputLatest, false, false, false, false
+}
+
+func TestParseStamp(t *testing.T) {
+	tests := []struct {
+		content string
+		version string
+		limit   int
+	}{
+		{unitStamp(80) + "[Unit]\n", version, 80},
+		{unitStamp(0) + "[Unit]\n", version, 0},
+		{"# Written by bat v0.16.0 for limit 60, changes get overwritten\n[Unit]\n", "0.16.0", 60},
+		{"[Unit]\nDescription=Persist battery BAT0 charge limit of 80% after suspend\n", "", 0},
+	}
+	for _, test := range tests {
+		v, limit := parseStamp(test.content)
+		if v != test.version || limit != test.limit {
+			t.Errorf("parseStamp(%q) = %q %d, want %q %d", test.content, v, limit, test.version, test.limit)
+		}
+	}
+}
+
+func TestOwnUnit(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{unitMarker + version + ", changes get overwritten\n[Unit]\n", true},
+		{"# Written by bat v0.17.0, changes get overwritten\n[Unit]\nDescription=Anything\n", true},
+		{"[Unit]\nDescription=Persist battery BAT0 charge limit of 80% after suspend\n", true},
+		{"[Unit]\nDescription=Calibrate battery BAT0 when its last calibration is 90d ago\n", true},
+		{"[Unit]\nDescription=My own charge limit\n", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := ownUnit(test.content); got != test.want {
+			t.Errorf("ownUnit(%q) = %v, want %v", test.content, got, test.want)
+		}
+	}
+}
+
+func TestSelectBatteries(t *testing.T) {
+	all := []string{syspath + "BAT0", syspath + "BAT1"}
+	tests := []struct {
+		env       string
+		batteries []string
+		fails     bool
+	}{
+		{"", all, false},
+		{"BAT1", all[1:], false},
+		{"BAT1,BAT0", []string{all[1], all[0]}, false},
+		{"BAT0 BAT1", all, false},
+		{" BAT0, ", all[:1], false},
+		{"BAT2", nil, true},
+		{"BAT0,CMB0", nil, true},
+	}
+	for _, test := range tests {
+		batteries, err := selectBatteries(all, splitNames(test.env))
+		if (err != nil) != test.fails {
+			t.Errorf("selectBatteries(%q) error: %v, want failure: %v", test.env, err, test.fails)
+			continue
+		}
+		if test.fails {
+			if !strings.HasSuffix(err.Error(), "available: BAT0 BAT1") {
+				t.Errorf("selectBatteries(%q) error %q does not list the batteries", test.env, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(batteries, test.batteries) {
+			t.Errorf("selectBatteries(%q) = %q, want %q", test.env, batteries, test.batteries)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		arg        string
+		start, end int
+		fails      bool
+	}{
+		{"80", -1, 80, false},
+		{"0", -1, 100, false},
+		{"100", -1, 100, false},
+		{"101", 0, 0, true},
+		{"x", 0, 0, true},
+		{"75-80", 75, 80, false},
+		{"0-100", 0, 100, false},
+		{"80-80", 0, 0, true},
+		{"80-75", 0, 0, true},
+		{"75-", 0, 0, true},
+		{"-80", 0, 0, true},
+		{"75-101", 0, 0, true},
+	}
+	for _, test := range tests {
+		start, end, err := parseLimit(test.arg)
+		if (err != nil) != test.fails {
+			t.Errorf("parseLimit(%q) error: %v, want failure: %v", test.arg, err, test.fails)
+			continue
+		}
+		if !test.fails && (start != test.start || end != test.end) {
+			t.Errorf("parseLimit(%q) = %d %d, want %d %d", test.arg, start, end, test.start, test.end)
+		}
+	}
+}
+
+func TestParseLimitArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		arg      string
+		duration time.Duration
+		fails    bool
+	}{
+		{[]string{"80"}, "80", 0, false},
+		{[]string{"100", "--for", "2h"}, "100", 2 * time.Hour, false},
+		{[]string{"--for", "90m", "75-80"}, "75-80", 90 * time.Minute, false},
+		{[]string{"78-80", "--fix"}, "78-80", 0, false},
+		{[]string{"100", "--for"}, "", 0, true},
+		{[]string{"100", "--for", "2"}, "", 0, true},
+		{[]string{"100", "--for", "30s"}, "", 0, true},
+		{[]string{"--for", "2h"}, "", 0, true},
+		{[]string{"80", "90"}, "", 0, true},
+		{nil, "", 0, true},
+	}
+	for _, test := range tests {
+		arg, duration, _, err := parseLimitArgs(test.args)
+		if (err != nil) != test.fails {
+			t.Errorf("parseLimitArgs(%q) error: %v, want failure: %v", test.args, err, test.fails)
+			continue
+		}
+		if !test.fails && (arg != test.arg || duration != test.duration) {
+			t.Errorf("parseLimitArgs(%q) = %q %v, want %q %v", test.args, arg, duration, test.arg, test.duration)
+		}
+	}
+}
+
+func TestFitGap(t *testing.T) {
+	tests := []struct {
+		start, limit, gap int
+		fitted            int
+	}{
+		{75, 80, 4, 75},
+		{78, 80, 4, 76},
+		{80, 80, 1, 79},
+		{2, 3, 5, 0},
+	}
+	for _, test := range tests {
+		fitted := fitGap(test.start, test.limit, test.gap)
+		if fitted != test.fitted {
+			t.Errorf("fitGap(%d, %d, %d) = %d, want %d", test.start, test.limit, test.gap, fitted, test.fitted)
+		}
+	}
+}
+
+func TestShortDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{2 * time.Hour, "2h"},
+		{90 * time.Minute, "1h30m"},
+		{45 * time.Minute, "45m"},
+		{100*time.Minute + 30*time.Second, "1h40m30s"},
+	}
+	for _, test := range tests {
+		got := shortDuration(test.duration)
+		if got != test.want {
+			t.Errorf("shortDuration(%v) = %q, want %q", test.duration, got, test.want)
+		}
+	}
+}
+
+func TestDescribeLimits(t *testing.T) {
+	tests := []struct {
+		limits []int
+		want   string
+	}{
+		{[]int{80, 100}, "80, 100"},
+		{[]int{50, 80, 100}, "50, 80, 100"},
+		{limitRange(10, 100), "10 to 100"},
+	}
+	for _, test := range tests {
+		got := describeLimits(test.limits)
+		if got != test.want {
+			t.Errorf("describeLimits(%v) = %q, want %q", test.limits, got, test.want)
+		}
+	}
+}
+
+func TestNearestLimit(t *testing.T) {
+	tests := []struct {
+		limit  int
+		limits []int
+		want   int
+	}{
+		{65, nil, 65},
+		{80, []int{80, 100}, 80},
+		{60, []int{80, 100}, 80},
+		{90, []int{80, 100}, 80},
+		{91, []int{80, 100}, 100},
+		{64, []int{50, 80, 100}, 50},
+		{65, []int{50, 80, 100}, 50},
+		{66, []int{50, 80, 100}, 80},
+	}
+	for _, test := range tests {
+		got := nearestLimit(test.limit, test.limits)
+		if got != test.want {
+			t.Errorf("nearestLimit(%d, %v) = %d, want %d", test.limit, test.limits, got, test.want)
+		}
+	}
+}
+
+func TestUpdateGrants(t *testing.T) {
+	grants := [][2]string{{"BAT0", "power"}, {"BAT1", "wheel"}}
+	tests := []struct {
+		battery, group, want string
+	}{
+		{"BAT0", "users", "BAT1 wheel\nBAT0 users\n"},
+		{"BAT0", "", "BAT1 wheel\n"},
+		{"BAT2", "power", "BAT0 power\nBAT1 wheel\nBAT2 power\n"},
+		{"BAT2", "", "BAT0 power\nBAT1 wheel\n"},
+	}
+	for _, test := range tests {
+		got := updateGrants(grants, test.battery, test.group)
+		if got != test.want {
+			t.Errorf("updateGrants(%q, %q) = %q, want %q", test.battery, test.group, got, test.want)
+		}
+	}
+	if got := updateGrants(nil, "BAT0", ""); got != "" {
+		t.Errorf("updateGrants(nil, %q, %q) = %q, want empty", "BAT0", "", got)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chargelimit-BAT0")
+	err := os.WriteFile(file, []byte("echo 80\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file, content string
+		mode          os.FileMode
+		want          bool
+	}{
+		{file, "echo 80\n", 0o755, true},
+		{file, "echo 60\n", 0o755, false},
+		{file, "echo 80\n", 0o644, false},
+		{file + ".missing", "echo 80\n", 0o755, false},
+	}
+	for _, test := range tests {
+		got := unchanged(test.file, test.content, test.mode)
+		if got != test.want {
+			t.Errorf("unchanged(%q, %q, %o) = %v, want %v", test.file, test.content, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestPowerdevilManages(t *testing.T) {
+	homes := t.TempDir()
+	configs := map[string]string{
+		"threshold": "[BAT0]\nBatteryChargeStopThreshold=80\n",
+		"other":     "[General]\nPowerButtonAction=1\n",
+	}
+	for home, config := range configs {
+		err := os.MkdirAll(filepath.Join(homes, home, ".config"), 0o755)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(homes, home, ".config", "powerdevilrc"), []byte(config), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		home string
+		want bool
+	}{
+		{"threshold", true},
+		{"other", false},
+		{"missing", false},
+	}
+	for _, test := range tests {
+		got := powerdevilManages(filepath.Join(homes, test.home))
+		if got != test.want {
+			t.Errorf("powerdevilManages(%q) = %v, want %v", test.home, got, test.want)
+		}
+	}
+}
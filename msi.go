@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MSI laptops keep the charge limit in the memory of the embedded
+// controller, which the ec_sys module shows in debugfs when it is loaded
+// with write_support=1. The byte at msiaddr is 0x80 plus the limit.
+const (
+	ecio    = "/sys/kernel/debug/ec/ec0/io"
+	msiaddr = 0xef
+	msibase = 0x80
+	msimin  = 10 // Limits that the firmware takes
+	msimax  = 100
+)
+
+// Return whether this is an MSI laptop with a sane value at msiaddr, as a
+// write to the wrong address can break the embedded controller
+func isMSI() bool {
+	if !strings.HasPrefix(readFile(dmivendor), "Micro-Star") {
+		return false
+	}
+	value, err := readEC(msiaddr)
+	return err == nil && value >= msibase+msimin && value <= msibase+msimax
+}
+
+func readEC(addr int64) (byte, error) {
+	f, err := os.Open(ecio)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	value := make([]byte, 1)
+	_, err = f.ReadAt(value, addr)
+	return value[0], err
+}
+
+func writeEC(addr int64, value byte) error {
+	f, err := os.OpenFile(ecio, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte{value}, addr)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The limit in the embedded controller memory of MSI laptops
+type msiBackend struct{}
+
+func (msiBackend) name() string { return "msi" }
+
+func (msiBackend) detect() bool { return isMSI() }
+
+func (msiBackend) get() (int, int) { return -1, msiLimit() }
+
+func (msiBackend) set(start, limit int) error { return msiSet(limit) }
+
+func (msiBackend) capabilities() capabilities {
+	return capabilities{limits: limitRange(msimin, msimax)}
+}
+
+func (msiBackend) command(start, limit int) string { return msiCommand(limit) }
+
+func msiLimit() int {
+	value, err := readEC(msiaddr)
+	if err != nil || value < msibase {
+		return 0
+	}
+	return int(value - msibase)
+}
+
+// Write limit to the embedded controller, only within the range of the
+// firmware, as any other byte at msiaddr can break it
+func msiSet(limit int) error {
+	if limit < msimin || limit > msimax {
+		return fmt.Errorf("the MSI firmware only takes a limit from %d to %d, not %d", msimin, msimax, limit)
+	}
+	return writeEC(msiaddr, byte(msibase+limit))
+}
+
+// Command for the persistence scripts, printf writes the byte in octal
+func msiCommand(limit int) string {
+	return fmt.Sprintf(`printf "\%03o" | dd of=%s bs=1 seek=%d count=1 conv=notrunc 2>/dev/null`,
+		msibase+limit, ecio, msiaddr)
+}
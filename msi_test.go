@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestMsiSetRange(t *testing.T) {
+	for _, limit := range []int{-1, 0, 5, 9, 101, 200} {
+		if err := msiSet(limit); err == nil {
+			t.Errorf("msiSet(%d) error: <nil>, want failure", limit)
+		}
+	}
+}
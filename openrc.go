@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+const (
+	initdir     = "/etc/init.d/"
+	runleveldir = "/etc/runlevels/default/"
+)
+
+// An init script in the default runlevel of OpenRC, like on Gentoo and Alpine
+type openrcInit struct{ initsystem.OpenRC }
+
+func (openrcInit) file() string { // I:bat
+	return initdir + prefix + bat
+}
+
+func (openrcInit) hook() string { return elogindHook() }
+
+func renderOpenRC(start, limit int) string { // I:bat
+	return fmt.Sprintf(openrcfile, bat, limit, bat, limit, bat, limit, persistCommand(start, limit))
+}
+
+func (b openrcInit) install(start, limit int) error { // I:bat
+	err := writeSystemFile(b.file(), renderOpenRC(start, limit), 0o755)
+	if err != nil {
+		return err
+	}
+	service := prefix + bat
+	err = runCommand("rc-update", "add", service, "default")
+	if err != nil {
+		return err
+	}
+	err = runCommand("rc-service", service, "restart")
+	if err != nil {
+		return err
+	}
+	return installElogindHook(start, limit)
+}
+
+func (b openrcInit) upToDate(start, limit int) bool { // I:bat
+	return unchanged(b.file(), renderOpenRC(start, limit), 0o755) && b.enabled() && elogindHookUpToDate(start, limit)
+}
+
+func (openrcInit) enabled() bool { // I:bat
+	_, err := os.Stat(runleveldir + prefix + bat)
+	return err == nil
+}
+
+func (b openrcInit) remove() bool { // I:bat
+	_, err := os.Stat(b.file())
+	if err != nil {
+		return false
+	}
+	runCommand("rc-update", "del", prefix+bat, "default")
+	removeFile(b.file())
+	if hook := b.hook(); hook != "" {
+		removeFile(hook)
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const udevdir = "/etc/udev/rules.d/"
+
+// Limit for a power source from the [power] section, once the laptop has
+// been on it for after
+type powerRule struct {
+	source string // "ac" or "battery"
+	after  time.Duration
+	limit  string
+}
+
+// Return the rules of the [power] section like ac = 80 or "ac 8h" = 60,
+// ordered by source and duration
+func parsePowerRules(section map[string]string) ([]powerRule, error) {
+	var rules []powerRule
+	for key, limit := range section {
+		source, after, found := strings.Cut(key, " ")
+		if source != "ac" && source != "battery" {
+			return nil, errors.New("config: power " + key + ": must start with ac or battery")
+		}
+		var duration time.Duration
+		if found {
+			var err error
+			duration, err = time.ParseDuration(strings.TrimSpace(after))
+			if err != nil || duration < time.Minute {
+				return nil, errors.New("config: power " + key + ": duration must be at least a minute, like: 8h")
+			}
+		}
+		_, _, err := parseLimit(limit)
+		if err != nil {
+			return nil, errors.New("config: power " + key + ": " + err.Error())
+		}
+		rules = append(rules, powerRule{source, duration, limit})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].source != rules[j].source {
+			return rules[i].source < rules[j].source
+		}
+		return rules[i].after < rules[j].after
+	})
+	return rules, nil
+}
+
+// Return the limit of the rule for source with the longest duration up to
+// elapsed, "" when none applies
+func powerLimit(rules []powerRule, source string, elapsed time.Duration) string {
+	limit := ""
+	for _, rule := range rules {
+		if rule.source == source && rule.after <= elapsed {
+			limit = rule.limit
+		}
+	}
+	return limit
+}
+
+// Return "ac" when a mains power supply is online, or else "battery"
+func powerSource() string {
+	supplies, _ := filepath.Glob(syspath + "*")
+	for _, supply := range supplies {
+		kind, _ := os.ReadFile(filepath.Join(supply, "type"))
+		online, _ := os.ReadFile(filepath.Join(supply, "online"))
+		if strings.TrimSpace(string(kind)) == "Mains" && strings.TrimSpace(string(online)) == "1" {
+			return "ac"
+		}
+	}
+	return "battery"
+}
+
+// Return the power source in the state file of the battery and since when,
+// "" when there is none
+func powerSince() (string, time.Time) { // I:powerstate
+	content, _ := os.ReadFile(powerstate)
+	source, date, _ := strings.Cut(strings.TrimSpace(string(content)), " ")
+	since, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return "", since
+	}
+	return source, since
+}
+
+// Record that the laptop is on source from now
+func recordPower(source string) error { // I:powerstate
+	err := os.MkdirAll(statedir, 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(powerstate, []byte(source+" "+time.Now().Format(time.RFC3339)+"\n"), 0o644)
+}
+
+// Start a transient timer for each rule of source with a duration, to
+// apply the rules again then, replacing the timers of the previous source
+func schedulePower(rules []powerRule, source string) error { // I:bat
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	stopPower()
+	for i, rule := range rules {
+		if rule.source != source || rule.after == 0 {
+			continue
+		}
+		unit := fmt.Sprintf("%s%s-power-%d", prefix, bat, i+1)
+		err = exec.Command("systemd-run", "--unit="+unit, fmt.Sprintf("--on-active=%ds", int(rule.after.Seconds())),
+			self, "-b", bat, "power").Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop the timers of the power rules of the battery
+func stopPower() { // I:bat
+	stopUnits(prefix + bat + "-power-*")
+}
+
+func renderPowerRule(self, systemdrun string) string { // I:bat
+	return fmt.Sprintf(powerudev, bat, systemdrun, self, bat)
+}
+
+// Install the udev rule that applies the power rules when the power source
+// changes
+func installPowerRule() error { // I:bat,powerrule
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	systemdrun, err := exec.LookPath("systemd-run")
+	if err != nil {
+		return err
+	}
+	err = writeSystemFile(powerrule, renderPowerRule(self, systemdrun), 0o644)
+	if err != nil {
+		return err
+	}
+	return exec.Command("udevadm", "control", "--reload").Run()
+}
+
+// Remove the udev rule, the timers and the state of the power rules, return
+// whether there was a rule
+func removePowerRule() bool { // I:powerrule,powerstate
+	err := removeFile(powerrule)
+	stopPower()
+	removeFile(powerstate)
+	if err == nil {
+		runCommand("udevadm", "control", "--reload")
+	}
+	return err == nil
+}
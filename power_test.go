@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePowerRules(t *testing.T) {
+	rules, err := parsePowerRules(map[string]string{"ac 8h": "60", "battery": "100", "ac": "80", "ac 30m": "70"})
+	if err != nil {
+		t.Fatalf("parsePowerRules: %v", err)
+	}
+	want := []powerRule{{"ac", 0, "80"}, {"ac", 30 * time.Minute, "70"}, {"ac", 8 * time.Hour, "60"}, {"battery", 0, "100"}}
+	if len(rules) != len(want) {
+		t.Fatalf("parsePowerRules = %v, want %v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %v, want %v", i, rules[i], want[i])
+		}
+	}
+
+	for key, limit := range map[string]string{"dock": "60", "ac 10s": "60", "ac soon": "60", "battery": "full"} {
+		_, err := parsePowerRules(map[string]string{key: limit})
+		if err == nil {
+			t.Errorf("parsePowerRules(%q = %q) did not fail", key, limit)
+		}
+	}
+}
+
+func TestPowerLimit(t *testing.T) {
+	rules := []powerRule{{"ac", 0, "80"}, {"ac", 8 * time.Hour, "60"}, {"battery", time.Hour, "100"}}
+	tests := []struct {
+		source  string
+		elapsed time.Duration
+		want    string
+	}{
+		{"ac", 0, "80"},
+		{"ac", 7 * time.Hour, "80"},
+		{"ac", 8 * time.Hour, "60"},
+		{"battery", time.Minute, ""},
+		{"battery", 2 * time.Hour, "100"},
+	}
+	for _, test := range tests {
+		if got := powerLimit(rules, test.source, test.elapsed); got != test.want {
+			t.Errorf("powerLimit(%s, %v) = %q, want %q", test.source, test.elapsed, got, test.want)
+		}
+	}
+}
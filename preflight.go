@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	minKernelMajor = 5 // 5.4-rc1 is the earliest to expose the threshold
+	minKernelMinor = 4
+	minSystemd     = 244 // oneshot not implemented before
+)
+
+// Results of the compatibility checks, so each runs at most once
+var preflights = map[string]error{}
+
+// Check that the requirements for changing the limit are met: the kernel,
+// the driver of the current battery, and for the "systemd" check systemd;
+// the "systemd-running" check only looks whether systemd is there at all
+func preflight(checks ...string) error { // I:bat
+	for _, check := range checks {
+		key := check
+		if check == "driver" { // The only one that depends on the battery
+			key += ":" + bat
+		}
+		err, done := preflights[key]
+		if !done {
+			switch check {
+			case "kernel":
+				err = checkKernel()
+			case "driver":
+				err = checkDriver()
+			case "systemd":
+				err = checkSystemd()
+			case "systemd-running":
+				_, err = systemdVersion()
+			}
+			preflights[key] = err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkKernel() error {
+	release := kernelRelease()
+	if release == "" {
+		return errors.New("cannot determine the kernel version")
+	}
+	major, minor, err := parseRelease(release)
+	if err != nil {
+		return err
+	}
+	if major < minKernelMajor || major == minKernelMajor && minor < minKernelMinor {
+		return fmt.Errorf("Linux kernel version %d.%d or later required", minKernelMajor, minKernelMinor)
+	}
+	return nil
+}
+
+// Return the release of the running kernel, like "6.8.0-45-generic", or ""
+// when it cannot be determined
+func kernelRelease() string {
+	var uts syscall.Utsname
+	err := syscall.Uname(&uts)
+	if err != nil {
+		return ""
+	}
+	release := make([]byte, 0, len(uts.Release))
+	for _, c := range uts.Release {
+		release = append(release, byte(c))
+	}
+	release, _, _ = bytes.Cut(release, []byte{0})
+	return string(release)
+}
+
+// Return major and minor of a kernel release like "6.8.0-45-generic" or
+// "5.15.0-rc3+", which may be padded with NULs
+func parseRelease(release string) (int, int, error) {
+	release, _, _ = strings.Cut(release, "\x00")
+	release = strings.TrimSpace(release)
+	invalid := errors.New("cannot read the kernel version from '" + release + "'")
+	// Only the leading numbers count, like "5.15.0" of "5.15.0-rc3+"
+	end := strings.IndexFunc(release, func(r rune) bool { return r != '.' && (r < '0' || r > '9') })
+	if end == -1 {
+		end = len(release)
+	}
+	numbers := strings.Split(release[:end], ".")
+	major, err := strconv.Atoi(numbers[0])
+	if err != nil {
+		return 0, 0, invalid
+	}
+	minor := 0
+	if len(numbers) > 1 && numbers[1] != "" {
+		minor, err = strconv.Atoi(numbers[1])
+		if err != nil {
+			return 0, 0, invalid
+		}
+	}
+	return major, minor, nil
+}
+
+func checkDriver() error { // I:thresholdpath,driver
+	_, err := os.Stat(thresholdpath)
+	if err != nil && driver == "" {
+		_, err = os.Stat(applesmc)
+		if err == nil {
+			return errors.New("the applesmc driver does not expose the BCLM key to write the charge limit to, a kernel with 'charge_control_end_threshold' for Macs is needed")
+		}
+		_, err = os.Stat(surfacebattery)
+		if err == nil {
+			return errors.New("the surface_battery driver does not expose a charge limit, enable Battery Limit mode (50%) in the Surface UEFI settings instead")
+		}
+		return errors.New("the driver does not expose '" + threshold + "', charge limit is not supported")
+	}
+	return nil
+}
+
+func checkSystemd() error {
+	version, err := systemdVersion()
+	if err != nil {
+		return err
+	}
+	if version < minSystemd {
+		return fmt.Errorf("systemd version %d-r1 or later required", minSystemd)
+	}
+	return nil
+}
+
+// Return the version of the running systemd, from the Version property of
+// its manager, like "255.4-1"
+func systemdVersion() (int, error) {
+	manager, err := systemdManager()
+	if err != nil {
+		return 0, err
+	}
+	property, err := manager.GetProperty(managerIface + ".Version")
+	if err != nil {
+		return 0, errors.New("cannot read the version of systemd: " + err.Error())
+	}
+	var version int
+	value, _ := property.Value().(string)
+	_, err = fmt.Sscanf(strings.TrimPrefix(value, "v"), "%d", &version)
+	if err != nil {
+		return 0, errors.New("cannot read the version of systemd from '" + value + "'")
+	}
+	return version, nil
+}
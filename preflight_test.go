@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		release      string
+		major, minor int
+		fails        bool
+	}{
+		{"6.8.0-45-generic", 6, 8, false},           // Ubuntu
+		{"5.15.0-rc3+", 5, 15, false},               // Self-built release candidate
+		{"5.4-rc1", 5, 4, false},                    // Earliest supported
+		{"6.14.2-300.fc42.x86_64", 6, 14, false},    // Fedora
+		{"6.12.21-1-lts", 6, 12, false},             // Arch LTS
+		{"6.1.0-31-amd64", 6, 1, false},             // Debian
+		{"6.4.0-150600.23.38-default", 6, 4, false}, // openSUSE
+		{"6.6.74_1", 6, 6, false},                   // Void
+		{"6.6.30-gentoo-dist", 6, 6, false},         // Gentoo
+		{"4.19.0", 4, 19, false},
+		{"10.2.3", 10, 2, false}, // Future kernels
+		{"7", 7, 0, false},
+		{"6.8.0-45-generic\x00\x00\x00\x00", 6, 8, false},
+		{"5.10.0\x00garbage", 5, 10, false},
+		{"", 0, 0, true},
+		{"\x00\x00", 0, 0, true},
+		{"generic-6.8", 0, 0, true},
+		{".8.0", 0, 0, true},
+		{"6.x", 6, 0, false},
+	}
+	for _, test := range tests {
+		major, minor, err := parseRelease(test.release)
+		if (err != nil) != test.fails {
+			t.Errorf("parseRelease(%q) error: %v, want failure: %v", test.release, err, test.fails)
+			continue
+		}
+		if !test.fails && (major != test.major || minor != test.minor) {
+			t.Errorf("parseRelease(%q) = %d.%d, want %d.%d", test.release, major, minor, test.major, test.minor)
+		}
+	}
+}
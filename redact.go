@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files of the DMI that identify the machine
+var dmisecrets = []string{"product_serial", "product_uuid", "board_serial", "chassis_serial"}
+
+// Shortest hostname that gets redacted, a shorter one identifies little and
+// would turn up as a word in the reports, like "bat"
+const minHostname = 4
+
+// Values that identify the machine or a battery, see secrets
+var knownSecrets []string
+
+// Return the values that identify the machine or a battery: the hostname and
+// the serial numbers, read once
+func secrets() []string {
+	if knownSecrets != nil {
+		return knownSecrets
+	}
+	knownSecrets = []string{}
+	hostname, err := os.Hostname()
+	if err == nil && len(hostname) >= minHostname {
+		knownSecrets = append(knownSecrets, hostname)
+	}
+	for _, battery := range findBatteries() {
+		knownSecrets = append(knownSecrets, readFile(filepath.Join(battery, "serial_number")))
+	}
+	for _, name := range dmisecrets {
+		knownSecrets = append(knownSecrets, readFile("/sys/class/dmi/id/"+name))
+	}
+	return knownSecrets
+}
+
+// Replace the secrets in the content of diagnostics, unless --no-redact
+// was given; everything that leaves the machine goes through here
+func redact(content string) string { // I:noRedact
+	if noRedact {
+		return content
+	}
+	return redactValues(content, secrets())
+}
+
+func redactValues(content string, secrets []string) string {
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if len(secret) > 1 { // Do not mangle everything for a serial like "0"
+			content = replaceWord(content, secret)
+		}
+	}
+	return content
+}
+
+// Replace word in content by REDACTED where it is not part of a longer
+// word, so a serial does not mangle a longer number that contains it
+func replaceWord(content, word string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; ; {
+		j := strings.Index(content[i:], word)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(word)
+		i = start + 1
+		if wordByte(content, start-1) || wordByte(content, end) {
+			continue
+		}
+		b.WriteString(content[last:start])
+		b.WriteString("REDACTED")
+		last, i = end, end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// Whether content has a letter, digit, _ or - at i
+func wordByte(content string, i int) bool {
+	if i < 0 || i >= len(content) {
+		return false
+	}
+	c := content[i]
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestRedactValues(t *testing.T) {
+	secrets := []string{"thinkpad", " 12345 ", "", "0", "PF3ABCDE"}
+	tests := []struct {
+		content, want string
+	}{
+		{"Oct 01 thinkpad systemd[1]: Started chargelimit-BAT0-suspend.service", "Oct 01 REDACTED systemd[1]: Started chargelimit-BAT0-suspend.service"},
+		{"12345\n", "REDACTED\n"},
+		{"PF3ABCDE", "REDACTED"},
+		{"capacity 80, cycle_count 0", "capacity 80, cycle_count 0"},
+		{"thinkpad.local thinkpads thinkpad-2", "REDACTED.local thinkpads thinkpad-2"},
+		{"12345 123456 PF3ABCDE12345", "REDACTED 123456 PF3ABCDE12345"},
+		{"thinkpad thinkpad", "REDACTED REDACTED"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got := redactValues(test.content, secrets)
+		if got != test.want {
+			t.Errorf("redactValues(%q) = %q, want %q", test.content, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func renderReloadRule(systemctl string) string { // I:bat
+	return fmt.Sprintf(reloadudev, bat, strings.Join(chargeModules, "|"), systemctl, eventUnit("multi-user"))
+}
+
+// Install the udev rule that restarts the boot unit when a charge limit
+// driver module gets loaded again, which resets the thresholds; return
+// whether it was not there like this yet. udev runs the rule without bat,
+// and the module events have no device unit to start through SYSTEMD_WANTS,
+// so the rule keeps running systemctl.
+func installReloadRule() (bool, error) { // I:bat,reloadrule
+	systemctl, err := exec.LookPath("systemctl")
+	if err != nil {
+		return false, err
+	}
+	rule := renderReloadRule(systemctl)
+	if unchanged(reloadrule, rule, 0o644) {
+		return false, nil
+	}
+	err = writeSystemFile(reloadrule, rule, 0o644)
+	if err != nil {
+		return true, err
+	}
+	return true, runCommand("udevadm", "control", "--reload")
+}
+
+// Remove the udev rule for module reloads, return whether there was one
+func removeReloadRule() bool { // I:reloadrule
+	err := removeFile(reloadrule)
+	if err == nil {
+		runCommand("udevadm", "control", "--reload")
+	}
+	return err == nil
+}
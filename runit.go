@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+const (
+	svdir      = "/etc/sv/"
+	servicedir = "/var/service/"
+	zzzdir     = "/etc/zzz.d/resume/"
+)
+
+// A runit service that sets the limit and keeps running, with a resume
+// hook for zzz, like on Void Linux
+type runitInit struct{ initsystem.Runit }
+
+func (runitInit) file() string { // I:bat
+	return svdir + prefix + bat + "/run"
+}
+
+func (runitInit) hook() string { // I:bat
+	return zzzdir + prefix + bat
+}
+
+func renderRunit(start, limit int) string { // I:bat
+	return fmt.Sprintf(runitfile, bat, limit, persistCommand(start, limit))
+}
+
+func renderZzz(start, limit int) string { // I:bat
+	return fmt.Sprintf(zzzfile, bat, limit, persistCommand(start, limit))
+}
+
+func (b runitInit) install(start, limit int) error { // I:bat
+	err := makeDir(svdir+prefix+bat, 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeSystemFile(b.file(), renderRunit(start, limit), 0o755)
+	if err != nil {
+		return err
+	}
+	if !b.enabled() { // The limit is already set, a running service can stay
+		err = symlink(svdir+prefix+bat, servicedir+prefix+bat)
+		if err != nil {
+			return err
+		}
+	}
+	err = makeDir(zzzdir, 0o755)
+	if err != nil {
+		return err
+	}
+	return writeSystemFile(b.hook(), renderZzz(start, limit), 0o755)
+}
+
+func (b runitInit) upToDate(start, limit int) bool { // I:bat
+	return unchanged(b.file(), renderRunit(start, limit), 0o755) && b.enabled() &&
+		unchanged(b.hook(), renderZzz(start, limit), 0o755)
+}
+
+func (runitInit) enabled() bool { // I:bat
+	_, err := os.Lstat(servicedir + prefix + bat)
+	return err == nil
+}
+
+func (b runitInit) remove() bool { // I:bat
+	_, err := os.Stat(svdir + prefix + bat)
+	if err != nil {
+		return false
+	}
+	removeFile(servicedir + prefix + bat) // runsvdir stops it
+	removeAll(svdir + prefix + bat)
+	removeFile(b.hook())
+	return true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pepa65/bat/internal/initsystem"
+)
+
+const s6dir = "/etc/s6/adminsd/"
+
+// A oneshot in the default bundle of s6-rc, like on Artix
+type s6Init struct{ initsystem.S6 }
+
+func (s6Init) file() string { // I:bat
+	return s6dir + prefix + bat + "/up"
+}
+
+func (s6Init) hook() string { return elogindHook() }
+
+func renderS6(start, limit int) string { // I:bat
+	return fmt.Sprintf(s6file, bat, limit, doubleQuote(persistCommand(start, limit)))
+}
+
+func (b s6Init) install(start, limit int) error { // I:bat
+	err := makeDir(s6dir+prefix+bat, 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeSystemFile(s6dir+prefix+bat+"/type", "oneshot\n", 0o644)
+	if err != nil {
+		return err
+	}
+	err = writeSystemFile(b.file(), renderS6(start, limit), 0o644)
+	if err != nil {
+		return err
+	}
+	err = makeDir(s6dir+"default/contents.d", 0o755)
+	if err != nil {
+		return err
+	}
+	err = writeSystemFile(s6dir+"default/contents.d/"+prefix+bat, "", 0o644)
+	if err != nil {
+		return err
+	}
+	err = runCommand("s6-db-reload")
+	if err != nil {
+		return err
+	}
+	err = runCommand("s6-rc", "-u", "change", prefix+bat)
+	if err != nil {
+		return err
+	}
+	return installElogindHook(start, limit)
+}
+
+func (b s6Init) upToDate(start, limit int) bool { // I:bat
+	return unchanged(b.file(), renderS6(start, limit), 0o644) && b.enabled() && elogindHookUpToDate(start, limit)
+}
+
+func (s6Init) enabled() bool { // I:bat
+	_, err := os.Stat(s6dir + "default/contents.d/" + prefix + bat)
+	return err == nil
+}
+
+func (b s6Init) remove() bool { // I:bat
+	_, err := os.Stat(s6dir + prefix + bat)
+	if err != nil {
+		return false
+	}
+	removeFile(s6dir + "default/contents.d/" + prefix + bat)
+	removeAll(s6dir + prefix + bat)
+	runCommand("s6-db-reload")
+	if hook := b.hook(); hook != "" {
+		removeFile(hook)
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package main
+
+import "os"
+
+// Commands that write files of the system, which in a sandbox would only
+// change the copy of the sandbox, if anything
+var hostCommands = map[string]bool{
+	"persist": true, "remove": true, "uninstall": true, "repair": true, "enable": true,
+	"enforce": true, "schedule": true, "revoke": true, "save": true,
+}
+
+// Return the sandbox that bat runs in, "flatpak" or "snap", or "" on the
+// host
+func sandbox() string {
+	_, err := os.Stat("/.flatpak-info")
+	if err == nil {
+		return "flatpak"
+	}
+	if os.Getenv("SNAP_NAME") != "" {
+		return "snap"
+	}
+	return "" // Containers like toolbox may well have the battery to change
+}
+
+// Return how to run bat on the host from the sandbox, which is also how a
+// sandboxed frontend gets its changes done: it reads the status itself and
+// leaves the rest to bat on the host
+func hostCommand(sandbox string) string {
+	if sandbox == "flatpak" { // Needs --talk-name=org.freedesktop.Flatpak
+		return "flatpak-spawn --host bat"
+	}
+	return "bat"
+}
+
+// Explain that the sandbox keeps bat from changing the system
+func sandboxed(sandbox string) string {
+	return "the " + sandbox + " sandbox keeps bat from changing the system, run on the host: " + hostCommand(sandbox)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Return the settings of the current battery that save keeps: the limit
+// like 80 or 75-80, and the charge behaviour and mode when it has those
+func currentSettings() map[string]string { // I:batpath,driver
+	settings := map[string]string{"limit": limitArg()}
+	_, settings["behaviour"] = parseChoices(mustRead(behaviour))
+	_, settings["mode"] = chargeModes()
+	for key, value := range settings {
+		if value == "" {
+			delete(settings, key)
+		}
+	}
+	return settings
+}
+
+// Return the content of a save file, a setting per line like: limit 80
+func formatSettings(settings map[string]string) string {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var content string
+	for _, key := range keys {
+		content += key + " " + settings[key] + "\n"
+	}
+	return content
+}
+
+func parseSettings(content string) map[string]string {
+	settings := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, found := strings.Cut(line, " ")
+		if found && value != "" {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func saveSettings(settings map[string]string) error { // I:savefile
+	err := os.MkdirAll(statedir, 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(savefile, []byte(formatSettings(settings)), 0o644)
+}
+
+// Apply the saved settings of the current battery and return the ones it
+// applied, the behaviour only when the driver has a choice of them
+func restoreSettings() (map[string]string, error) { // I:savefile,batpath
+	content, err := os.ReadFile(savefile)
+	if err != nil {
+		return nil, err
+	}
+	settings := parseSettings(string(content))
+	if settings["limit"] != "" {
+		start, limit, err := parseLimit(settings["limit"])
+		if err != nil {
+			return settings, err
+		}
+		err = setThresholds(start, limit)
+		if err != nil {
+			return settings, err
+		}
+	}
+	if settings["mode"] != "" {
+		_, err = setChargeMode(settings["mode"])
+		if err != nil {
+			return settings, err
+		}
+	}
+	if settings["behaviour"] != "" && !hasBehaviour(settings["behaviour"]) {
+		delete(settings, "behaviour")
+	}
+	if settings["behaviour"] != "" {
+		err = setBehaviour(settings["behaviour"])
+		if err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
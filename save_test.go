@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSettings(t *testing.T) {
+	tests := []struct {
+		settings map[string]string
+		content  string
+	}{
+		{map[string]string{"limit": "80"}, "limit 80\n"},
+		{map[string]string{"limit": "75-80", "behaviour": "auto", "mode": "Long Life"}, "behaviour auto\nlimit 75-80\nmode Long Life\n"},
+		{map[string]string{}, ""},
+	}
+	for _, test := range tests {
+		content := formatSettings(test.settings)
+		if content != test.content {
+			t.Errorf("formatSettings(%v) = %q, want %q", test.settings, content, test.content)
+		}
+		settings := parseSettings(content)
+		if !reflect.DeepEqual(settings, test.settings) {
+			t.Errorf("parseSettings(%q) = %v, want %v", content, settings, test.settings)
+		}
+	}
+}
+
+func TestRestoreBehaviour(t *testing.T) {
+	dir := t.TempDir()
+	selectBattery(filepath.Join(dir, "BAT0"), 0)
+	driver = ""
+	savefile = filepath.Join(dir, "saved-BAT0")
+	err := os.Mkdir(batpath, 0o755)
+	if err == nil {
+		err = os.WriteFile(savefile, []byte("behaviour inhibit-charge\n"), 0o644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		behaviours string // Content of charge_behaviour, "" for a driver without it
+		want       map[string]string
+		written    string
+	}{
+		{"", map[string]string{}, ""},
+		{"[auto] inhibit-charge force-discharge\n", map[string]string{"behaviour": "inhibit-charge"}, "inhibit-charge"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(batpath, behaviour)
+		if test.behaviours != "" {
+			err = os.WriteFile(file, []byte(test.behaviours), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		settings, err := restoreSettings()
+		if err != nil {
+			t.Errorf("restoreSettings() with charge_behaviour %q error: %v", test.behaviours, err)
+		}
+		if !reflect.DeepEqual(settings, test.want) {
+			t.Errorf("restoreSettings() with charge_behaviour %q = %v, want %v", test.behaviours, settings, test.want)
+		}
+		if content, _ := os.ReadFile(file); test.written != "" && string(content) != test.written {
+			t.Errorf("restoreSettings() wrote %q, want %q", content, test.written)
+		}
+	}
+}
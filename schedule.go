@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Words that can start a schedule, for the days of systemd calendar events
+var scheduleDays = map[string]string{"weekdays": "Mon..Fri", "weekends": "Sat,Sun"}
+
+// Limit to set at a systemd calendar event, from the [schedule] section
+type scheduled struct {
+	calendar string
+	limit    string
+}
+
+// Return the entries of the [schedule] section like "weekdays 08:00" = 60,
+// ordered by calendar event
+func parseSchedule(section map[string]string) ([]scheduled, error) {
+	var entries []scheduled
+	for when, limit := range section {
+		_, _, err := parseLimit(limit)
+		if err != nil {
+			return nil, errors.New("config: schedule " + when + ": " + err.Error())
+		}
+		day, clock, found := strings.Cut(when, " ")
+		if days := scheduleDays[strings.ToLower(day)]; days != "" && found {
+			when = days + " " + clock
+		}
+		entries = append(entries, scheduled{when, limit})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].calendar < entries[j].calendar })
+	return entries, nil
+}
+
+// Name of the service that applies scheduled entry n of the current battery
+func scheduleUnit(n int) string { // I:bat
+	return unitName("schedule-" + strconv.Itoa(n))
+}
+
+func renderScheduleService(self string, entry scheduled) string { // I:bat
+	return fmt.Sprintf(scheduleservice, bat, entry.limit, self, bat, entry.limit)
+}
+
+func renderScheduleTimer(entry scheduled) string { // I:bat
+	return fmt.Sprintf(scheduletimer, bat, entry.limit, entry.calendar, entry.calendar)
+}
+
+// Check the calendar events of entries with systemd-analyze
+func checkCalendars(entries []scheduled) error {
+	for _, entry := range entries {
+		output, err := exec.Command("systemd-analyze", "calendar", entry.calendar).CombinedOutput()
+		if errors.Is(err, exec.ErrNotFound) {
+			return errors.New("systemd-analyze not found, it checks the schedule")
+		}
+		if err != nil {
+			message, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+			return errors.New("config: schedule " + entry.calendar + ": " + message)
+		}
+	}
+	return nil
+}
+
+// Replace the schedule timers of the battery by ones for entries
+func installSchedule(entries []scheduled) error { // I:bat
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	removeSchedule()
+	for i, entry := range entries {
+		service := scheduleUnit(i + 1)
+		timer := strings.TrimSuffix(service, ".service") + ".timer"
+		err = writeUnitFile(services+service, renderScheduleService(self, entry), 0)
+		if err != nil {
+			return err
+		}
+		err = writeUnitFile(services+timer, renderScheduleTimer(entry), 0)
+		if err != nil {
+			return err
+		}
+		err = enableUnits(timer)
+		if err == nil {
+			err = startUnit(timer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop and remove the schedule timers of the battery, return how many
+// there were
+func removeSchedule() int { // I:bat
+	timers, _ := filepath.Glob(services + prefix + bat + "-schedule-*.timer")
+	for _, file := range timers {
+		timer := filepath.Base(file)
+		service := strings.TrimSuffix(timer, ".timer") + ".service"
+		disableUnits(timer)
+		stopUnit(timer)
+		stopUnit(service)
+		removeFile(file)
+		removeFile(services + service)
+	}
+	if timers != nil {
+		daemonReload()
+	}
+	return len(timers)
+}
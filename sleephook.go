@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindName  = "org.freedesktop.login1"
+	logindPath  = "/org/freedesktop/login1"
+	logindIface = logindName + ".Manager"
+)
+
+func renderSleepHook(self string, start, limit int) string { // I:bat
+	return fmt.Sprintf(sleephookservice, bat, limit, self, bat, formatLimit(start, limit))
+}
+
+// Take a delay lock on sleep from logind, it holds until the returned file
+// gets closed
+func delaySleep() (*os.File, error) { // I:bat
+	_, err := systemdManager()
+	if err != nil {
+		return nil, err
+	}
+	var fd dbus.UnixFD
+	err = systemdConn.Object(logindName, logindPath).Call(logindIface+".Inhibit", 0,
+		"sleep", "bat", "bat: setting the charge limit of battery "+bat, "delay").Store(&fd)
+	if err != nil {
+		return nil, systemdError(err)
+	}
+	return os.NewFile(uintptr(fd), "inhibit"), nil
+}
+
+// Keep the thresholds over sleep: set them before sleep while holding a
+// delay lock, release the lock so the sleep can go on, and set them again on
+// resume, as some firmware resets the thresholds over sleep; start -1 keeps
+// the current start
+func sleepHook(start, limit int) error { // I:bat
+	lock, err := delaySleep()
+	if err != nil {
+		return err
+	}
+	err = systemdConn.AddMatchSignal(dbus.WithMatchInterface(logindIface), dbus.WithMatchMember("PrepareForSleep"))
+	if err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 4)
+	systemdConn.Signal(signals)
+	defer systemdConn.RemoveSignal(signals)
+	for signal := range signals {
+		if signal.Name != logindIface+".PrepareForSleep" || len(signal.Body) == 0 {
+			continue
+		}
+		sleeping, _ := signal.Body[0].(bool)
+		err = setThresholds(start, limit)
+		if err != nil {
+			warn("could not set the charge limit of " + strconv.Itoa(limit) + ": " + err.Error())
+		}
+		if sleeping {
+			lock.Close()
+			lock = nil
+			continue
+		}
+		if lock == nil {
+			lock, err = delaySleep()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return errors.New("lost the connection to the system bus")
+}
+
+// Install and (re)start the service that keeps the thresholds over sleep
+// through logind, instead of the system-sleep script; return whether it was
+// not installed and enabled like this yet
+func installSleepHook(start, limit int) (bool, error) { // I:bat
+	self, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	service := unitName("sleep-hook")
+	content := renderSleepHook(self, start, limit)
+	if unchanged(services+service, unitStamp(limit)+content, 0o644) && unitState(service) == "enabled" {
+		return false, nil
+	}
+	err = writeUnitFile(services+service, content, limit)
+	if err != nil {
+		return true, err
+	}
+	daemonReload()
+	err = enableUnits(service)
+	if err != nil {
+		return true, err
+	}
+	stopUnit(service)
+	return true, startUnit(service)
+}
+
+// Stop and remove the sleep hook service, return whether there was one
+func removeSleepHook() bool { // I:bat
+	service := unitName("sleep-hook")
+	_, err := os.Stat(services + service)
+	if err != nil {
+		return false
+	}
+	disableUnits(service)
+	stopUnit(service)
+	err = removeFile(services + service)
+	daemonReload()
+	return err == nil
+}
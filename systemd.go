@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdName  = "org.freedesktop.systemd1"
+	managerPath  = "/org/freedesktop/systemd1"
+	managerIface = systemdName + ".Manager"
+)
+
+// Connection to the system bus, made on first use by systemdManager
+var systemdConn *dbus.Conn
+
+var errNoSystemd = errors.New("cannot reach systemd on the system bus")
+
+// Return the system manager of systemd on the system bus
+func systemdManager() (dbus.BusObject, error) {
+	if systemdConn == nil {
+		conn, err := dbus.ConnectSystemBus()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errNoSystemd, err)
+		}
+		systemdConn = conn
+	}
+	return systemdConn.Object(systemdName, managerPath), nil
+}
+
+// The calls on the system manager, behind an interface so the tests can
+// fake systemd
+type systemdBus interface {
+	// Call method of the system manager with args
+	call(method string, args ...any) *dbus.Call
+	// Send the JobRemoved signals of the system manager to signals, until
+	// the returned function gets called
+	watchJobs(signals chan *dbus.Signal) (func(), error)
+}
+
+// The system manager on the system bus, or a fake in the tests
+var bus systemdBus
+
+// The system manager over the connection of systemdManager
+type systemBus struct{ conn *dbus.Conn }
+
+func (b systemBus) call(method string, args ...any) *dbus.Call {
+	return b.conn.Object(systemdName, managerPath).Call(managerIface+"."+method, 0, args...)
+}
+
+func (b systemBus) watchJobs(signals chan *dbus.Signal) (func(), error) {
+	match := []dbus.MatchOption{dbus.WithMatchInterface(managerIface), dbus.WithMatchMember("JobRemoved")}
+	err := b.conn.AddMatchSignal(match...)
+	if err != nil {
+		return nil, err
+	}
+	b.conn.Signal(signals)
+	return func() {
+		b.conn.RemoveSignal(signals)
+		b.conn.RemoveMatchSignal(match...)
+	}, nil
+}
+
+// Return bus, connecting to the system manager on first use
+func managerBus() (systemdBus, error) {
+	if bus == nil {
+		_, err := systemdManager()
+		if err != nil {
+			return nil, err
+		}
+		bus = systemBus{systemdConn}
+	}
+	return bus, nil
+}
+
+// Map the errors of the bus that callers act on: missing privileges to
+// os.ErrPermission and unknown units to os.ErrNotExist
+func systemdError(err error) error {
+	var busErr dbus.Error
+	if !errors.As(err, &busErr) {
+		return err
+	}
+	switch busErr.Name {
+	case "org.freedesktop.DBus.Error.AccessDenied", "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired":
+		return fmt.Errorf("%w: %v", os.ErrPermission, err)
+	case "org.freedesktop.systemd1.NoSuchUnit", "org.freedesktop.DBus.Error.FileNotFound":
+		return fmt.Errorf("%w: %v", os.ErrNotExist, err)
+	}
+	return err
+}
+
+// Call method of the system manager with args, storing the reply in
+// results
+func callManager(method string, args []any, results ...any) error {
+	b, err := managerBus()
+	if err != nil {
+		return err
+	}
+	call := b.call(method, args...)
+	if call.Err != nil {
+		return systemdError(call.Err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return call.Store(results...)
+}
+
+// Queue a job for unit through method, like StartUnit, and wait until it
+// is done; return an error when it ended otherwise
+func runJob(method, unit string) error {
+	b, err := managerBus()
+	if err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 16)
+	stop, err := b.watchJobs(signals)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	var job dbus.ObjectPath
+	err = callManager(method, []any{unit, "replace"}, &job)
+	if err != nil {
+		return err
+	}
+	for signal := range signals {
+		if signal.Name != managerIface+".JobRemoved" || len(signal.Body) < 4 {
+			continue
+		}
+		if path, _ := signal.Body[1].(dbus.ObjectPath); path != job {
+			continue
+		}
+		if result, _ := signal.Body[3].(string); result != "done" {
+			return fmt.Errorf("job for %s ended as %s", unit, result)
+		}
+		return nil
+	}
+	return errNoSystemd
+}
+
+func startUnit(unit string) error {
+	if dryRun {
+		plan("start " + unit)
+		return nil
+	}
+	return runJob("StartUnit", unit)
+}
+
+// Stop unit and clear its failed state, so it does not linger in
+// 'systemctl --failed' after its removal
+func stopUnit(unit string) {
+	if dryRun {
+		if state := unitState(unit); state != "" && state != "not-found" {
+			plan("stop " + unit)
+		}
+		return
+	}
+	runJob("StopUnit", unit)
+	callManager("ResetFailedUnit", []any{unit})
+}
+
+// Stop and clear the failed state of the loaded units matching pattern,
+// like "chargelimit-BAT0-power-*.timer"
+func stopUnits(pattern string) {
+	var units []struct {
+		Name, Description, Load, Active, Sub, Following string
+		Path                                            dbus.ObjectPath
+		Job                                             uint32
+		JobType                                         string
+		JobPath                                         dbus.ObjectPath
+	}
+	err := callManager("ListUnitsByPatterns", []any{[]string{}, []string{pattern}}, &units)
+	if err != nil {
+		return
+	}
+	for _, unit := range units {
+		stopUnit(unit.Name)
+	}
+}
+
+// Enable the unit files, or instances of templates, like 'systemctl enable'
+func enableUnits(units ...string) error {
+	if dryRun {
+		plan("enable " + strings.Join(units, " "))
+		return daemonReload()
+	}
+	var install bool
+	var changes [][]string
+	err := callManager("EnableUnitFiles", []any{units, false, true}, &install, &changes)
+	if err != nil {
+		return err
+	}
+	return daemonReload()
+}
+
+// Disable the unit files, like 'systemctl disable'
+func disableUnits(units ...string) error {
+	if dryRun {
+		for _, unit := range units {
+			if unitState(unit) == "enabled" {
+				plan("disable " + unit)
+			}
+		}
+		return nil
+	}
+	var changes [][]string
+	err := callManager("DisableUnitFiles", []any{units, false}, &changes)
+	if err != nil {
+		return err
+	}
+	return daemonReload()
+}
+
+// Return the state of the unit file, like 'systemctl is-enabled': enabled,
+// disabled, static, not-found, or "" when systemd cannot tell
+func unitState(unit string) string {
+	var state string
+	err := callManager("GetUnitFileState", []any{unit}, &state)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return "not-found"
+	case err != nil:
+		return ""
+	}
+	return state
+}
+
+func daemonReload() error {
+	if dryRun {
+		plan("reload systemd")
+		return nil
+	}
+	return callManager("Reload", nil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// A system manager that answers a job call with job and then sends signals
+type fakeBus struct {
+	job      dbus.ObjectPath
+	err      error
+	signals  []*dbus.Signal
+	watching chan *dbus.Signal
+	stopped  bool
+}
+
+func (f *fakeBus) call(method string, args ...any) *dbus.Call {
+	if f.err != nil {
+		return &dbus.Call{Err: f.err}
+	}
+	for _, signal := range f.signals {
+		f.watching <- signal
+	}
+	close(f.watching)
+	return &dbus.Call{Body: []any{f.job}}
+}
+
+func (f *fakeBus) watchJobs(signals chan *dbus.Signal) (func(), error) {
+	f.watching = signals
+	return func() { f.stopped = true }, nil
+}
+
+// Return a JobRemoved signal for job with result
+func jobRemoved(job dbus.ObjectPath, result string) *dbus.Signal {
+	return &dbus.Signal{Name: managerIface + ".JobRemoved", Body: []any{uint32(1), job, "chargelimit-BAT0@suspend.service", result}}
+}
+
+func TestRunJob(t *testing.T) {
+	job := dbus.ObjectPath("/org/freedesktop/systemd1/job/42")
+	other := dbus.ObjectPath("/org/freedesktop/systemd1/job/41")
+	tests := []struct {
+		name    string
+		bus     *fakeBus
+		wantErr error
+		wantMsg string
+	}{
+		{"done", &fakeBus{job: job, signals: []*dbus.Signal{jobRemoved(other, "failed"), jobRemoved(job, "done")}}, nil, ""},
+		{"failed", &fakeBus{job: job, signals: []*dbus.Signal{jobRemoved(job, "failed")}}, nil, "job for chargelimit-BAT0@suspend.service ended as failed"},
+		{"other signals", &fakeBus{job: job, signals: []*dbus.Signal{
+			{Name: managerIface + ".UnitNew", Body: []any{"x", job}},
+			{Name: managerIface + ".JobRemoved", Body: []any{uint32(1), job}},
+			jobRemoved(other, "done"),
+		}}, errNoSystemd, ""},
+		{"denied", &fakeBus{err: dbus.Error{Name: "org.freedesktop.DBus.Error.AccessDenied", Body: []any{"denied"}}}, os.ErrPermission, ""},
+	}
+	defer func() { bus = nil }()
+	for _, test := range tests {
+		bus = test.bus
+		err := runJob("StartUnit", "chargelimit-BAT0@suspend.service")
+		switch {
+		case test.wantMsg != "":
+			if err == nil || err.Error() != test.wantMsg {
+				t.Errorf("%s: runJob error %v, want %q", test.name, err, test.wantMsg)
+			}
+		case test.wantErr == nil && err != nil, test.wantErr != nil && !errors.Is(err, test.wantErr):
+			t.Errorf("%s: runJob error %v, want %v", test.name, err, test.wantErr)
+		}
+		if !test.bus.stopped {
+			t.Errorf("%s: runJob did not stop watching the jobs", test.name)
+		}
+	}
+}
+
+func TestSystemdError(t *testing.T) {
+	plain := errors.New("connection closed")
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.AccessDenied"}, os.ErrPermission},
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired"}, os.ErrPermission},
+		{dbus.Error{Name: "org.freedesktop.systemd1.NoSuchUnit"}, os.ErrNotExist},
+		{dbus.Error{Name: "org.freedesktop.DBus.Error.FileNotFound"}, os.ErrNotExist},
+		{dbus.Error{Name: "org.freedesktop.systemd1.UnitMasked"}, nil},
+		{plain, plain},
+	}
+	for _, test := range tests {
+		got := systemdError(test.err)
+		switch {
+		case test.want == nil:
+			if errors.Is(got, os.ErrPermission) || errors.Is(got, os.ErrNotExist) {
+				t.Errorf("systemdError(%v) = %v, want it unmapped", test.err, got)
+			}
+		case !errors.Is(got, test.want):
+			t.Errorf("systemdError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
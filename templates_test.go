@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestTemplates(t *testing.T) {
+	tests := []struct {
+		golden  string
+		battery string
+		index   int
+		driver  string
+		render  func() string
+	}{
+		{"unit-BAT0", "BAT0", 0, "", func() string { return renderUnit("/bin/sh", -1, 80) }},
+		{"unit-BAT1", "BAT1", 1, "", func() string { return renderUnit("/usr/bin/sh", -1, 60) }},
+		{"unit-BATT", "BATT", 0, "", func() string { return renderUnit("/bin/sh", -1, 55) }},
+		{"sleep-BAT0", "BAT0", 0, "", func() string { return renderSleep(-1, 80) }},
+		{"sleep-BATC", "BATC", 0, "", func() string { return renderSleep(-1, 65) }},
+		{"test-BAT0", "BAT0", 0, "", func() string { return renderTest("/bin/sh", 80) }},
+		{"inhibit-BAT0", "BAT0", 0, "", func() string { return renderInhibit("/bin/sh", 80) }},
+		{"tlp-BAT1", "BAT1", 1, "", func() string { return renderTLP(-1, 70) }},
+		{"tlp-range-BAT0", "BAT0", 0, "", func() string { return renderTLP(75, 80) }},
+		{"openrc-BAT0", "BAT0", 0, "", func() string { return renderOpenRC(-1, 80) }},
+		{"runit-BAT0", "BAT0", 0, "", func() string { return renderRunit(-1, 80) }},
+		{"zzz-BAT0", "BAT0", 0, "", func() string { return renderZzz(-1, 80) }},
+		{"s6-BAT0", "BAT0", 0, "", func() string { return renderS6(-1, 80) }},
+		{"cron-BAT0", "BAT0", 0, "", func() string { return renderCron(-1, 80) }},
+		{"pm-sleep-BAT0", "BAT0", 0, "", func() string { return renderPmSleep(-1, 80) }},
+		{"dinit-huawei", "BAT0", 0, "huawei", func() string { return renderDinit(-1, 70) }},
+		{"grant-BAT0", "BAT0", 0, "", func() string {
+			return renderGrant("power", []string{syspath + "BAT0/" + startvariable, syspath + "BAT0/" + threshold})
+		}},
+		{"earlyboot-BAT0", "BAT0", 0, "", func() string { return renderEarlyboot(80) }},
+		{"dracut-BAT0", "BAT0", 0, "", renderDracut},
+		{"unit-huawei", "BAT0", 0, "huawei", func() string { return renderUnit("/bin/sh", -1, 70) }},
+		{"unit-range-huawei", "BAT0", 0, "huawei", func() string { return renderUnit("/bin/sh", 60, 70) }},
+		{"sleep-huawei", "BAT0", 0, "huawei", func() string { return renderSleep(-1, 70) }},
+		{"unit-lg", "BAT0", 0, "lg", func() string { return renderUnit("/bin/sh", -1, 80) }},
+		{"unit-msi", "BAT1", 1, "msi", func() string { return renderUnit("/bin/sh", -1, 60) }},
+		{"sleep-msi", "BAT1", 1, "msi", func() string { return renderSleep(-1, 60) }},
+		{"sleep-sony", "BAT1", 0, "sony", func() string { return renderSleep(-1, 50) }},
+		{"calibrate-BAT0", "BAT0", 0, "", func() string { return renderCalibrateService("/usr/local/bin/bat", "90d") }},
+		{"calibrate-timer-BAT0", "BAT0", 0, "", renderCalibrateTimer},
+		{"schedule-BAT0", "BAT0", 0, "", func() string {
+			return renderScheduleService("/usr/local/bin/bat", scheduled{"Mon..Fri 08:00", "60"})
+		}},
+		{"schedule-timer-BAT0", "BAT0", 0, "", func() string { return renderScheduleTimer(scheduled{"Mon..Fri 08:00", "60"}) }},
+		{"power-BAT1", "BAT1", 1, "", func() string { return renderPowerRule("/usr/local/bin/bat", "/usr/bin/systemd-run") }},
+		{"enforce-BAT0", "BAT0", 0, "", func() string { return renderEnforceService("/bin/sh", -1, 80) }},
+		{"enforce-path-BAT0", "BAT0", 0, "", func() string { return renderEnforcePath(80) }},
+		{"sleep-hook-BAT0", "BAT0", 0, "", func() string { return renderSleepHook("/usr/local/bin/bat", -1, 80) }},
+		{"sleep-hook-range-BAT0", "BAT0", 0, "", func() string { return renderSleepHook("/usr/local/bin/bat", 75, 80) }},
+		{"report-BAT0", "BAT0", 0, "", func() string {
+			h := batHealth{Battery: "BAT0", Manufacturer: "SMP", Model: "5B10W13930", Technology: "Li-poly", Health: 85,
+				Full: 4250000, Design: 5000000, Cycles: 312, Manufactured: "2022-07-01", AgeMonths: 20,
+				History: []capacityPoint{{time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), 4600000}}}
+			return renderReport(h, "LENOVO 20XW", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC))
+		}},
+		{"reload-BAT0", "BAT0", 0, "", func() string { return renderReloadRule("/usr/bin/systemctl") }},
+	}
+	for _, test := range tests {
+		selectBattery(syspath+test.battery, test.index)
+		driver = test.driver
+		got := test.render()
+		golden := filepath.Join("testdata", test.golden+".golden")
+		if *update {
+			err := os.WriteFile(golden, []byte(got), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: rendered\n%s\nwant\n%s", test.golden, got, want)
+		}
+	}
+}
+
+func TestPersistCommandStart(t *testing.T) {
+	dir := t.TempDir()
+	selectBattery(filepath.Join(dir, "BAT0"), 0)
+	driver = ""
+	end := filepath.Join(dir, "BAT0", threshold)
+	start := filepath.Join(dir, "BAT0", startvariable)
+	tests := []struct {
+		hasstart bool
+		start    int
+		want     string
+	}{
+		{false, 75, "echo 80 >" + end},
+		{true, -1, "echo 80 >" + end},
+		{true, 75, "echo 75 >" + start + " 2>/dev/null; echo 80 >" + end + " && echo 75 >" + start},
+	}
+	err := os.Mkdir(filepath.Join(dir, "BAT0"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if test.hasstart {
+			err = os.WriteFile(start, []byte("0\n"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := persistCommand(test.start, 80)
+		if got != test.want {
+			t.Errorf("persistCommand(%d, 80) with start file %v = %q, want %q", test.start, test.hasstart, got, test.want)
+		}
+	}
+}
+
+func TestUnitPathsResolved(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "devices", "BAT0")
+	err := os.MkdirAll(device, 0o755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(device, threshold), []byte("80\n"), 0o644)
+	}
+	if err == nil {
+		err = os.Symlink(device, filepath.Join(dir, "BAT0"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectBattery(filepath.Join(dir, "BAT0"), 0)
+	driver = ""
+	want, _ := filepath.EvalSymlinks(filepath.Join(device, threshold)) // The temporary directory can be a symlink too
+	got := unitPaths()
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("unitPaths() = %q, want [%q]", got, want)
+	}
+}
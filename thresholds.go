@@ -0,0 +1,481 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	chargetype  = "charge_type"
+	chargetypes = "charge_types"
+	behaviour   = "charge_behaviour"
+)
+
+// Huawei laptops keep both thresholds in a single platform file, like "40 70"
+const huaweifile = "/sys/devices/platform/huawei-wmi/charge_control_thresholds"
+
+// Intel Macs have the System Management Controller here, it only offers
+// the charge limit (the BCLM key) on kernels that add the generic file
+const applesmc = "/sys/devices/platform/applesmc.768"
+
+// Microsoft Surface devices report the battery through the Surface System
+// Aggregator, which offers no thresholds: Battery Limit mode is a UEFI setting
+const surfacebattery = "/sys/module/surface_battery"
+
+// Vendor of the laptop, for the drivers that need to know
+const dmivendor = "/sys/class/dmi/id/sys_vendor"
+
+// Toshiba and dynabook laptops use the power_supply file, but eco charging
+// only knows 80 and full
+const toshibamodule = "/sys/module/toshiba_acpi"
+
+// Vendor drivers with a single platform file that only takes a few limits
+var limitfiles = map[string]string{
+	"lg":   "/sys/devices/platform/lg-laptop/battery_care_limit",
+	"sony": "/sys/devices/platform/sony-laptop/battery_care_limiter",
+}
+
+// A way to get and set the thresholds of the current battery
+type backend interface {
+	// Name of the driver, "" for the power_supply files
+	name() string
+	// Whether the backend works for the current battery
+	detect() bool
+	// Return the start and end threshold, start -1 when there is none and
+	// end 0 when the limit cannot be read
+	get() (int, int)
+	// Set the end threshold to limit and the start threshold to start, or
+	// when start is -1 keep the current start threshold if possible
+	set(start, limit int) error
+	capabilities() capabilities
+}
+
+// What a backend supports
+type capabilities struct {
+	start  bool  // A start threshold
+	limits []int // The limits it accepts, nil when any of 1-100 goes
+}
+
+// A backend that sets the limit by writing a file, so the persistence
+// scripts and the boot hooks can do it, and a group can get a grant
+type fileBackend interface {
+	backend
+	// Return the file and the value to write to it to set limit, and start
+	// when the file holds both; start -1 keeps the current one
+	write(start, limit int) (string, string)
+	// Return the files that need to be writable to change the thresholds
+	files() []string
+}
+
+// A file backend with the start threshold in a file of its own
+type startFileBackend interface {
+	fileBackend
+	// Return the file and the value to write to it to set start
+	writeStart(start int) (string, string)
+}
+
+// A backend that works through a tool or debugfs instead of a sysfs file
+type toolBackend interface {
+	backend
+	// Return the shell command that sets limit, and start when the tool
+	// takes one; start -1 keeps the current one
+	command(start, limit int) string
+}
+
+// All backends, in the order they get detected, the power_supply files
+// come first as the vendor drivers are only the fallback
+var backends = []backend{
+	toshibaBackend{},
+	sysfsBackend{},
+	huaweiBackend{},
+	careBackend{"lg", []int{80, 100}},
+	careBackend{"sony", []int{50, 80, 100}},
+	msiBackend{},
+	frameworkBackend{},
+	crosecBackend{},
+}
+
+// Driver that keeps the thresholds of the current battery, set by
+// selectBattery: "" for the power_supply files, or a vendor driver
+var driver string
+
+func detectDriver() string {
+	for _, b := range backends {
+		if b.detect() {
+			return b.name()
+		}
+	}
+	return ""
+}
+
+// Return the backend of the current battery
+func currentBackend() backend { // I:driver
+	for _, b := range backends {
+		if b.name() == driver {
+			return b
+		}
+	}
+	return sysfsBackend{}
+}
+
+// Return all limits from low to high
+func limitRange(low, high int) []int {
+	var limits []int
+	for limit := low; limit <= high; limit++ {
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+// Return whether the driver works through a tool or debugfs instead of a
+// sysfs file, so the limit cannot be applied from the boot hooks or by a
+// group
+func viaTool() bool { // I:driver
+	_, ok := currentBackend().(toolBackend)
+	return ok
+}
+
+func getThresholds() (int, int) { // I:driver
+	return currentBackend().get()
+}
+
+// Smallest gap between the start and end threshold that the firmware
+// takes, by the start of sys_vendor; some ThinkPads ignore a write with a
+// smaller one without an error
+var firmwareGaps = map[string]int{"LENOVO": 4}
+
+// Return the smallest gap between the start and end threshold, from the
+// gap default in the config file or else the firmware of the vendor
+func minGap() int { // I:defaults
+	gap, err := strconv.Atoi(defaults["gap"])
+	if err == nil {
+		return gap
+	}
+	vendor := strings.ToUpper(readFile(dmivendor))
+	for prefix, gap := range firmwareGaps {
+		if strings.HasPrefix(vendor, prefix) {
+			return gap
+		}
+	}
+	return 1
+}
+
+// Return start, lowered so it is at least gap below limit
+func fitGap(start, limit, gap int) int {
+	if limit-start >= gap {
+		return start
+	}
+	if limit-gap < 0 {
+		return 0
+	}
+	return limit - gap
+}
+
+func hasStart() bool { // I:driver,batpath
+	return currentBackend().capabilities().start
+}
+
+func setThresholds(start, limit int) error { // I:driver
+	err := followEnforce(start, limit)
+	if err != nil {
+		return err
+	}
+	return currentBackend().set(start, limit)
+}
+
+// Return the file and the value that the persistence scripts write to it
+// to set limit, for a driver that does not work through a tool
+func persistWrite(start, limit int) (string, string) { // I:driver
+	return currentBackend().(fileBackend).write(start, limit)
+}
+
+// Return the shell command that the persistence units and hooks run to
+// set limit, and start when it is not -1 and the driver has one
+func persistCommand(start, limit int) string { // I:driver
+	b := currentBackend()
+	if tool, ok := b.(toolBackend); ok {
+		return tool.command(start, limit)
+	}
+	path, value := b.(fileBackend).write(start, limit)
+	command := "echo " + value + " >" + path
+	if sb, ok := b.(startFileBackend); ok && start >= 0 && b.capabilities().start {
+		// The driver refuses a start above the limit, so whichever of the two
+		// is in the way, the start goes in either before or after the limit
+		startpath, startvalue := sb.writeStart(start)
+		setstart := "echo " + startvalue + " >" + startpath
+		command = setstart + " 2>/dev/null; " + command + " && " + setstart
+	}
+	return command
+}
+
+// Return the files that need to be writable to change the thresholds, nil
+// when the driver works through a tool
+func grantPaths() []string { // I:driver
+	if files, ok := currentBackend().(fileBackend); ok {
+		return files.files()
+	}
+	return nil
+}
+
+// Return the files that the persistence units write, the only ones that
+// their sandbox leaves writable; the other tools write through /dev. The
+// power_supply files are symlinks into /sys/devices, and systemd binds the
+// path it is given, so they get resolved
+func unitPaths() []string { // I:driver
+	if _, ok := currentBackend().(msiBackend); ok { // Through debugfs
+		return []string{ecio}
+	}
+	var paths []string
+	for _, path := range grantPaths() {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			path = resolved
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Return the limits that the tool backends read from the embedded
+// controller, by driver, to check the sysfs limit against
+func firmwareLimits() map[string]int {
+	limits := map[string]int{}
+	for _, b := range backends {
+		if _, ok := b.(toolBackend); ok && b.detect() {
+			_, limits[b.name()] = b.get()
+		}
+	}
+	return limits
+}
+
+// The charge_control_*_threshold files of the power_supply class
+type sysfsBackend struct{}
+
+func (sysfsBackend) name() string { return "" }
+
+func (sysfsBackend) detect() bool { // I:thresholdpath
+	_, err := os.Stat(thresholdpath)
+	return err == nil
+}
+
+func (sysfsBackend) get() (int, int) { // I:batpath
+	end, _ := strconv.Atoi(mustRead(threshold))
+	start, err := strconv.Atoi(mustRead(startvariable))
+	if err != nil {
+		start = -1
+	}
+	return start, end
+}
+
+func (b sysfsBackend) set(start, limit int) error { // I:thresholdpath,batpath
+	_, end := b.get()
+	if start < 0 || !b.capabilities().start {
+		return writeValue(thresholdpath, strconv.Itoa(limit))
+	}
+	paths := []string{thresholdpath, filepath.Join(batpath, startvariable)}
+	values := []int{limit, start}
+	if limit <= end { // Lower the start first, it has to stay below the end
+		paths[0], paths[1], values[0], values[1] = paths[1], paths[0], start, limit
+	}
+	for i, path := range paths {
+		err := writeValue(path, strconv.Itoa(values[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sysfsBackend) capabilities() capabilities { // I:batpath
+	_, err := os.Stat(filepath.Join(batpath, startvariable))
+	return capabilities{start: err == nil}
+}
+
+func (sysfsBackend) write(start, limit int) (string, string) { // I:thresholdpath
+	return thresholdpath, strconv.Itoa(limit)
+}
+
+func (sysfsBackend) writeStart(start int) (string, string) { // I:batpath
+	return filepath.Join(batpath, startvariable), strconv.Itoa(start)
+}
+
+func (b sysfsBackend) files() []string { // I:thresholdpath,batpath
+	paths := []string{thresholdpath}
+	if b.capabilities().start {
+		paths = append(paths, filepath.Join(batpath, startvariable))
+	}
+	return paths
+}
+
+// The power_supply files of toshiba_acpi, which only take the eco charging
+// limit or full
+type toshibaBackend struct{ sysfsBackend }
+
+func (toshibaBackend) name() string { return "toshiba" }
+
+func (b toshibaBackend) detect() bool {
+	_, err := os.Stat(toshibamodule)
+	return err == nil && b.sysfsBackend.detect()
+}
+
+func (b toshibaBackend) capabilities() capabilities {
+	c := b.sysfsBackend.capabilities()
+	c.limits = []int{80, 100}
+	return c
+}
+
+// The platform file of huawei_wmi with both thresholds
+type huaweiBackend struct{}
+
+func (huaweiBackend) name() string { return "huawei" }
+
+func (huaweiBackend) detect() bool {
+	_, err := os.Stat(huaweifile)
+	return err == nil
+}
+
+func (huaweiBackend) get() (int, int) {
+	var start, end int
+	_, err := fmt.Sscanf(readFile(huaweifile), "%d %d", &start, &end)
+	if err != nil {
+		return -1, 0
+	}
+	return start, end
+}
+
+func (b huaweiBackend) set(start, limit int) error {
+	current, _ := b.get()
+	return writeValue(huaweifile, huaweiValue(start, current, limit))
+}
+
+func (huaweiBackend) capabilities() capabilities {
+	return capabilities{start: true}
+}
+
+func (b huaweiBackend) write(start, limit int) (string, string) {
+	current, _ := b.get()
+	return huaweifile, huaweiValue(start, current, limit)
+}
+
+func (huaweiBackend) files() []string {
+	return []string{huaweifile}
+}
+
+// The Huawei file needs both thresholds with the start below the end
+func huaweiValue(start, current, limit int) string {
+	if start < 0 {
+		start = current
+	}
+	if start < 0 || start >= limit {
+		start = 0
+	}
+	return fmt.Sprintf("%d %d", start, limit)
+}
+
+// The battery care file of lg_laptop or sony_laptop, with only a limit
+type careBackend struct {
+	driver string
+	limits []int
+}
+
+func (b careBackend) name() string { return b.driver }
+
+func (b careBackend) detect() bool {
+	_, err := os.Stat(limitfiles[b.driver])
+	return err == nil
+}
+
+func (b careBackend) get() (int, int) {
+	end, _ := strconv.Atoi(readFile(limitfiles[b.driver]))
+	if end == 0 && b.driver == "sony" { // Sony shows no limit as 0
+		end = 100
+	}
+	return -1, end
+}
+
+func (b careBackend) set(start, limit int) error {
+	return writeValue(limitfiles[b.driver], strconv.Itoa(limit))
+}
+
+func (b careBackend) capabilities() capabilities {
+	return capabilities{limits: b.limits}
+}
+
+func (b careBackend) write(start, limit int) (string, string) {
+	return limitfiles[b.driver], strconv.Itoa(limit)
+}
+
+func (b careBackend) files() []string {
+	return []string{limitfiles[b.driver]}
+}
+
+// Charge modes of the power_supply class, as listed in the kernel ABI
+var abiModes = []string{"Trickle", "Fast", "Standard", "Adaptive", "Custom", "Long Life", "Bypass"}
+
+// Return the charge modes that the battery supports and the current one.
+// Newer kernels list them in charge_types like "[Standard] Adaptive Fast",
+// older ones only show the current mode in charge_type.
+func chargeModes() ([]string, string) { // I:batpath
+	types := mustRead(chargetypes)
+	if types != "" {
+		return parseChoices(types)
+	}
+	current := mustRead(chargetype)
+	if current == "" {
+		return nil, ""
+	}
+	return abiModes, current
+}
+
+// Return the choices in a list like "[Standard] Adaptive Fast" and the
+// current one, which is in brackets
+func parseChoices(list string) ([]string, string) {
+	var choices []string
+	current := ""
+	for _, choice := range strings.Fields(list) {
+		if strings.HasPrefix(choice, "[") {
+			choice = strings.Trim(choice, "[]")
+			current = choice
+		}
+		choices = append(choices, choice)
+	}
+	return choices, current
+}
+
+// Return whether the battery supports charge_behaviour value
+func hasBehaviour(value string) bool { // I:batpath
+	behaviours, _ := parseChoices(mustRead(behaviour))
+	for _, b := range behaviours {
+		if b == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Set charge_behaviour to value, which the driver has to support
+func setBehaviour(value string) error { // I:batpath
+	if !hasBehaviour(value) {
+		return errors.New("'" + value + "' is not supported by the driver, it needs '" + behaviour + "' (kernel 5.19+)")
+	}
+	return writeValue(filepath.Join(batpath, behaviour), value)
+}
+
+// Set the charge mode, given in any case, and return its proper name
+func setChargeMode(mode string) (string, error) { // I:batpath
+	modes, _ := chargeModes()
+	for _, m := range modes {
+		if strings.EqualFold(m, mode) {
+			file := chargetypes
+			if mustRead(chargetypes) == "" {
+				file = chargetype
+			}
+			return m, writeValue(filepath.Join(batpath, file), m)
+		}
+	}
+	return "", errors.New("mode must be one of: " + strings.Join(modes, ", "))
+}
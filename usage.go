@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A command or option for the help text, the man page and the completions,
+// with the options that only go with a command
+type usageEntry struct {
+	usage   string // Like "[l[imit]] <int>", optional letters in brackets
+	text    string
+	options []usageEntry
+}
+
+// The commands, the only place to add one: help.tmpl and bat.1 get rendered
+// from it by 'go test -update', the completions when they get printed
+var commandSpec = []usageEntry{
+	{usage: "[s[tatus]]", text: "Display charge level, limit, health & persist status.", options: []usageEntry{
+		{usage: "-e|--errors", text: "Also list the files that could not be read and why."},
+		{usage: "--full", text: "Also show the state of each persistence unit and the sleep hook."},
+		{usage: "--design", text: "Also show the level as a percentage of the design capacity."},
+	}},
+	{usage: "[l[imit]] <int>", text: "Set the charge limit to <int> percent."},
+	{usage: "[l[imit]] <s>-<e>", text: "Set the start and end threshold, like: 75-80.", options: []usageEntry{
+		{usage: "--for <time>", text: "Only for <time>, like 2h, then go back to the current limit."},
+		{usage: "--fix", text: "Lower the start when it is too close to the end for the firmware."},
+	}},
+	{usage: "full [--for <time>]", text: "Charge to full once, then limit again (after 12h at most)."},
+	{usage: "profile [<name>]", text: "List the profiles or apply one, like: profile travel."},
+	{usage: "mode [<mode>]", text: "Display or set the charge mode (Dell charge_type)."},
+	{usage: "discharge on|off", text: "Start or stop discharging the battery, even on AC."},
+	{usage: "inhibit on|off", text: "Pause or resume the charging, like on a dock."},
+	{usage: "calibrate", text: "Charge to full, discharge and charge again, then limit again.", options: []usageEntry{
+		{usage: "--schedule <d>", text: "Check daily to calibrate when the last time is <d> ago, like 90d, or off."},
+	}},
+	{usage: "health", text: "Show the health, capacity, cycles and age of the battery.", options: []usageEntry{
+		{usage: "--report <file>", text: "Write it as a printable HTML report, for a warranty claim or a sale."},
+	}},
+	{usage: "enforce [off]", text: "Set the limit again when another tool changes it, or stop."},
+	{usage: "schedule [off]", text: "Set the limits of the [schedule] config section by timers, or stop."},
+	{usage: "power", text: "Set the limit of the [power] config section for the power source.", options: []usageEntry{
+		{usage: "--install", text: "Also do it on every change of the power source, or --remove."},
+	}},
+	{usage: "save", text: "Keep the limit, charge behaviour and mode in a state file."},
+	{usage: "restore", text: "Apply the settings kept by 'save' again."},
+	{usage: "p[ersist]", text: "Persist the charge limit after driver reloads.", options: []usageEntry{
+		{usage: "--via-tlp", text: "Persist through a tlp drop-in instead of systemd."},
+		{usage: "--via-systemd", text: "Persist through systemd, over the backend of the config file."},
+		{usage: "--via-<init>", text: "Persist through init system openrc, runit, s6 or dinit, found without systemd."},
+		{usage: "--cron", text: "Persist through an @reboot cron entry, when no init system is supported."},
+		{usage: "--test", text: "Also check once on the next boot that the limit got applied."},
+		{usage: "--inhibit-boot", text: "Also pause the charging in boot until the limit is applied."},
+		{usage: "--logind", text: "Keep the limit over sleep through a logind lock, not a system-sleep script."},
+		{usage: "--dry-run", text: "Only list the files it would write and the units it would change."},
+	}},
+	{usage: "repair", text: "Regenerate the persistence units that another version of bat wrote."},
+	{usage: "enable", text: "Enable the persistence units again, without rewriting them."},
+	{usage: "r[emove]", text: "Do not persist the charge limit after driver reloads.", options: []usageEntry{
+		{usage: "--dry-run", text: "Only list the files it would remove and the units it would change."},
+	}},
+	{usage: "check", text: "Compare the limit in sysfs with the embedded controller."},
+	{usage: "earlyboot", text: "Display whether the limit gets applied in early boot.", options: []usageEntry{
+		{usage: "--generate [<t>]", text: "Add a hook for initramfs tool <t>: dracut or initramfs-tools."},
+	}},
+	{usage: "grant <group>", text: "Let members of <group> change the limit without root.", options: []usageEntry{
+		{usage: "--list", text: "Display which group may change the limit."},
+	}},
+	{usage: "revoke", text: "Take back the grant, only root can change the limit."},
+	{usage: "helper", text: "Display which group may change the settings through bat-helper.", options: []usageEntry{
+		{usage: "--install <group>", text: "Install bat-helper for <group>, so bat needs no root for the settings."},
+		{usage: "--remove", text: "Remove bat-helper."},
+	}},
+	{usage: "uninstall", text: "Remove the persistence, the grant and the schedule, enforce, power and calibration units.", options: []usageEntry{
+		{usage: "--dry-run", text: "Only list the files it would remove and the units it would change."},
+	}},
+	{usage: "devices", text: "List the battery devices (one name per line with --plumbing)."},
+	{usage: "doctor", text: "Display what is supported and what would enable the rest."},
+	{usage: "bugreport [<file>]", text: "Bundle the details for an issue in <file> (bat-bugreport.tar.gz)."},
+	{usage: "h[elp]", text: "Just display this help text."},
+	{usage: "v[ersion]", text: "Just display version information."},
+	{usage: "completion [<sh>]", text: "Print the completion script for bash (default), zsh or fish."},
+}
+var globalSpec = []usageEntry{
+	{usage: "-b|--battery <bats>", text: "Only use the named batteries, like: -b BAT0,BAT1"},
+	{usage: "--stable-output <n>", text: "Keep the output in format version <n> (latest: %d)."},
+	{usage: "--json", text: "Output JSON instead of text."},
+	{usage: "--text", text: "Output text, over the output of the config file."},
+	{usage: "--strict", text: "Fail the status when a value cannot be read."},
+	{usage: "--force", text: "Overwrite unit files that bat did not write."},
+	{usage: "--no-redact", text: "Keep serial numbers and hostname in diagnostics."},
+	{usage: "--takeover", text: "Change the limit even when the desktop manages it."},
+	{usage: "--defer", text: "Leave the limit alone when the desktop manages it."},
+}
+
+// Return the help template, with the version and outputLatest as verbs
+func renderHelp() string {
+	text := "bat v%s - Manage battery charge limit\nRepo:  github.com/pepa65/bat\n" +
+		"Usage: bat [<global options>] <option>\n" +
+		"  Options (those that change a setting or install files need root privileges):\n"
+	for _, command := range commandSpec {
+		text += fmt.Sprintf("    %-20s %s\n", command.usage, command.text)
+		for _, option := range command.options {
+			text += fmt.Sprintf("      %-18s %s\n", option.usage, option.text)
+		}
+	}
+	text += "  Global options:\n"
+	for _, option := range globalSpec {
+		text += fmt.Sprintf("    %-20s %s\n", option.usage, option.text)
+	}
+	return text
+}
+
+// Escape text for a roff line
+func manEscape(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\e`)
+	return strings.ReplaceAll(text, "-", `\-`)
+}
+
+// Return the man page in roff
+func renderMan() string {
+	text := ".TH BAT 1 \"\" \"bat\" \"User Commands\"\n.SH NAME\nbat \\- Manage battery charge limit\n" +
+		".SH SYNOPSIS\n.B bat\n[\\fIglobal options\\fR] [\\fIcommand\\fR]\n" +
+		".SH DESCRIPTION\nThe commands that change a setting or install files need root privileges,\n" +
+		"unless \\fBgrant\\fR or \\fBhelper\\fR lets a group change the settings;\n" +
+		"the ones that only display something, like \\fBstatus\\fR and \\fBdoctor\\fR, need none.\n" +
+		"Letters in brackets can be left out, like \\fBp\\fR for \\fBpersist\\fR.\n.SH COMMANDS\n"
+	for _, command := range commandSpec {
+		text += ".TP\n.B " + manEscape(command.usage) + "\n" + manEscape(command.text) + "\n"
+		if command.options != nil {
+			text += ".RS\n"
+			for _, option := range command.options {
+				text += ".TP\n.B " + manEscape(option.usage) + "\n" + manEscape(option.text) + "\n"
+			}
+			text += ".RE\n"
+		}
+	}
+	text += ".SH GLOBAL OPTIONS\n"
+	for _, option := range globalSpec {
+		option.text = strings.ReplaceAll(option.text, "%d", strconv.Itoa(outputLatest))
+		text += ".TP\n.B " + manEscape(option.usage) + "\n" + manEscape(option.text) + "\n"
+	}
+	return text + ".SH FILES\n.TP\n" + configfile + "\nThe config file.\n.TP\n" + manEscape(statedir) +
+		"\nThe state of the batteries.\n.SH SEE ALSO\nhttps://github.com/pepa65/bat\n"
+}
+
+// Return the name of command, its usage without the optional brackets
+func commandWord(command usageEntry) string {
+	return strings.NewReplacer("[", "", "]", "").Replace(strings.Fields(command.usage)[0])
+}
+
+// Return the names of the commands, in the order of the help text
+func commandWords() []string {
+	var words []string
+	for _, command := range commandSpec {
+		word := commandWord(command)
+		if len(words) == 0 || words[len(words)-1] != word {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Return the description of each command for the completions, its first
+// help text without the period
+func commandDescriptions() map[string]string {
+	descriptions := map[string]string{}
+	for _, command := range commandSpec {
+		word := commandWord(command)
+		if descriptions[word] == "" {
+			descriptions[word] = strings.TrimSuffix(command.text, ".")
+		}
+	}
+	return descriptions
+}
+
+// Return the completion script for shell, with the commands filled in
+func renderCompletion(shell string) string {
+	words := commandWords()
+	descriptions := commandDescriptions()
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	switch shell {
+	case "zsh":
+		var entries []string
+		for _, word := range words {
+			entries = append(entries, `"`+word+":"+quote.Replace(descriptions[word])+`"`)
+		}
+		return fmt.Sprintf(zshcompletion, strings.Join(entries, " "))
+	case "fish":
+		var lines []string
+		for _, word := range words {
+			lines = append(lines, fmt.Sprintf(`complete -c bat -n "not __fish_seen_subcommand_from $commands" -a %s -d "%s"`,
+				word, quote.Replace(descriptions[word])))
+		}
+		return fmt.Sprintf(fishcompletion, strings.Join(words, " "), strings.Join(lines, "\n"))
+	}
+	return fmt.Sprintf(bashcompletion, strings.Join(words, " "))
+}
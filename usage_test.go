@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The help template and the man page are rendered from the command spec,
+// 'go test -update' rewrites them
+func TestUsage(t *testing.T) {
+	for file, got := range map[string]string{"help.tmpl": renderHelp(), "bat.1": renderMan()} {
+		if *update {
+			err := os.WriteFile(file, []byte(got), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s is not in sync with the command spec, run: go test -update", file)
+		}
+	}
+
+	readme, err := os.ReadFile("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	help := fmt.Sprintf(renderHelp(), version, outputLatest)
+	if !strings.Contains(string(readme), "```\n"+help+"```\n") {
+		t.Errorf("the usage in README.md is not the help text")
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	words := commandWords()
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		completion := renderCompletion(shell)
+		if strings.Contains(completion, "%!") {
+			t.Errorf("%s completion has a bad verb", shell)
+		}
+		for _, word := range words {
+			entry := " " + word + " " // In the list of commands
+			switch shell {
+			case "zsh":
+				entry = `"` + word + ":"
+			case "fish":
+				entry = "-a " + word + " -d "
+			}
+			if !strings.Contains(completion, entry) && !strings.Contains(completion, `"`+word+" ") {
+				t.Errorf("%s completion lacks command %s", shell, word)
+			}
+		}
+	}
+}